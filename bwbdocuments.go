@@ -17,39 +17,31 @@ package main
  */
 
 import (
-	"archive/zip"
 	"bytes"
-	"crypto/sha256"
+	"context"
 	"database/sql"
 	"encoding/xml"
 	"fmt"
 	_ "github.com/lib/pq"
 	"io"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
-const BWBidListURL = "http://wetten.overheid.nl/BWBIdService/BWBIdList.xml.zip"
-
-type BWBIdServiceResultaat struct {
-	XMLName           xml.Name       `xml:"BWBIdServiceResultaat"`
-	GegenereerdOp     string         `xml:"GegenereerdOp"`
-	RegelingInfoLijst []RegelingInfo `xml:"RegelingInfoLijst>RegelingInfo"`
-}
-
 type RegelingInfo struct {
-	XMLName                xml.Name `xml:"RegelingInfo"`
-	BWBId                  string   `xml:"BWBId"`
-	DatumLaatsteWijziging  string   `xml:"DatumLaatsteWijziging"`
-	VervalDatum            string   `xml:"VervalDatum"`
-	OfficieleTitel         string   `xml:"OfficieleTitel"`
-	Titel                  string   `xml:"CiteertitelLijst>Citeertitel>titel"`
-	Status                 string   `xml:"CiteertitelLijst>Citeertitel>status"`
-	InwerkingtredingsDatum string   `xml:"CiteertitelLijst>Citeertitel>InwerkingtredingsDatum"`
-	RegelingSoort          string   `xml:"RegelingSoort"`
+	XMLName               xml.Name            `xml:"RegelingInfo"`
+	BWBId                 string              `xml:"BWBId"`
+	DatumLaatsteWijziging string              `xml:"DatumLaatsteWijziging"`
+	VervalDatum           string              `xml:"VervalDatum"`
+	OfficieleTitel        string              `xml:"OfficieleTitel"`
+	Citeertitels          []Citeertitel       `xml:"CiteertitelLijst>Citeertitel"`
+	NietOfficieleTitels   []string            `xml:"NietOfficieleTitelLijst>NietOfficieleTitel"`
+	Afkortingen           []string            `xml:"AfkortingLijst>Afkorting"`
+	RegelingSoort         string              `xml:"RegelingSoort"`
+	Verantwoordelijken    []Verantwoordelijke `xml:"VerantwoordelijkeLijst>Verantwoordelijke"`
+	EURichtlijnen         []string            `xml:"EU-RichtlijnLijst>EU-Richtlijn"`
 }
 
 type BWBDocument struct {
@@ -98,234 +90,377 @@ func generateBWBDocuments(db *sql.DB) chan BWBDocument {
 	return ch
 }
 
-func DownloadBWBIdList() (*BWBIdServiceResultaat, error) {
-	log.Println("Downloading BWBIdList zip.")
-	bwblist := new(BWBIdServiceResultaat)
-	resp, err := http.Get(BWBidListURL)
-	if err != nil {
-		return bwblist, err
-	}
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return bwblist, err
-	}
-	defer resp.Body.Close()
-	bodyreader := bytes.NewReader(body)
+// Convert an empty string to NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
 
-	zipfile, err := zip.NewReader(bodyreader, int64(bodyreader.Len()))
-	if err != nil {
-		return bwblist, err
-	}
+// The query for the version of bwbid valid on date at SnapshotPath.
+func getbwbquery(bwbid string, date time.Time) url.Values {
+	v := url.Values{}
+	v.Set("regelingID", bwbid)
+	v.Set("geldigheidsdatum", fmt.Sprintf("%02d-%02d-%04d", date.Day(), date.Month(), date.Year()))
+	return v
+}
 
-	for _, f := range zipfile.File {
-		if f.Name == "BWBIdList.xml" {
-			reader, err := f.Open()
-			if err != nil {
-				return bwblist, err
-			}
-			decoder := xml.NewDecoder(reader)
-			bwblist := new(BWBIdServiceResultaat)
-			err = decoder.Decode(bwblist)
-			if err != nil {
-				return bwblist, err
-			}
-			return bwblist, nil
+// Selects the regelingen to sync. Empty lists match everything.
+type SyncFilter struct {
+	Soorten []string // Regelingsoorten, e.g. wet, AMvB, ministeriele-regeling
+	BWBIds  []string
+	// Skip regelingen of which we hold a version that took effect on or
+	// after their DatumLaatsteWijziging, unless a version took effect or
+	// expired since the last sync.
+	OnlyChanged bool
+}
+
+// Parse a comma separated list, ignoring empty elements.
+func splitList(list string) []string {
+	result := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
 		}
 	}
-	return bwblist, nil
+	return result
 }
 
-func StoreBWBIdList(connectionURL string) error {
+// The WHERE clause and arguments selecting the documents matching the filter.
+func (f SyncFilter) where() (string, []interface{}) {
+	conditions := []string{"TRUE"}
+	args := []interface{}{}
+	if len(f.Soorten) > 0 {
+		args = append(args, strings.Join(f.Soorten, ","))
+		conditions = append(conditions, fmt.Sprintf("regelingsoort = ANY(string_to_array($%d, ','))", len(args)))
+	}
+	if len(f.BWBIds) > 0 {
+		args = append(args, strings.Join(f.BWBIds, ","))
+		conditions = append(conditions, fmt.Sprintf("bwbid = ANY(string_to_array($%d, ','))", len(args)))
+	}
+	if f.OnlyChanged {
+		// Changes are often published before they take effect, so a
+		// regeling stays selected until the version of its last change has
+		// been found. Also re-scan regelingen whose newest version expired
+		// since the last sync, or that came into force since then.
+		conditions = append(conditions, `(NOT EXISTS (SELECT 1 FROM bwb_snapshots s
+			WHERE s.bwbid = bwb_documents.bwbid
+			AND COALESCE(s.geldig_van, s.pubdate::date) >= laatstewijziging)
+		OR EXISTS (SELECT 1 FROM bwb_snapshots s
+			WHERE s.bwbid = bwb_documents.bwbid
+			AND s.pubdate = (SELECT MAX(pubdate) FROM bwb_snapshots WHERE bwb_snapshots.bwbid = bwb_documents.bwbid)
+			AND s.geldig_tot <= CURRENT_DATE
+			AND (laatstesync IS NULL OR s.geldig_tot >= laatstesync::date))
+		OR COALESCE(startdatum > laatstesync::date AND startdatum <= CURRENT_DATE, FALSE))`)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// Queue the regelingen matching filter and sync their snapshots. If a
+// previous run was interrupted its unfinished jobs are done first.
+func SyncSnapshots(ctx context.Context, connectionURL string, filter SyncFilter, strategy SyncStrategy, workers int) (SyncSummary, error) {
+
 	// Connect to the database
 	db, err := sql.Open("postgres", connectionURL)
 	if err != nil {
-		return err
+		return SyncSummary{}, err
 	}
 	defer db.Close()
-	bwblist, err := DownloadBWBIdList()
+
+	// New versions are only recognised if the stored hashes use the current
+	// canonical form too, so finish the work of -rehash first.
+	stale, err := StaleSnapshots(db)
 	if err != nil {
-		return err
+		return SyncSummary{}, err
 	}
-	log.Println("Filling bwb_documents table")
-	tx, err := db.Begin()
+	if stale > 0 {
+		log.Println("Rehashing", stale, "snapshots before syncing.")
+		if _, _, err = rehashSnapshots(db); err != nil {
+			return SyncSummary{}, err
+		}
+	}
+
+	// Continue an interrupted run, or queue a new one
+	unfinished, err := UnfinishedSyncJobs(db)
 	if err != nil {
+		return SyncSummary{}, err
+	}
+	if unfinished > 0 {
+		log.Println("Resuming sync with", unfinished, "unfinished jobs.")
+	} else {
+		n, err := EnqueueSyncJobs(db, filter)
+		if err != nil {
+			return SyncSummary{}, err
+		}
+		log.Println("Queued", n, "sync jobs.")
+	}
+	pool := SyncPool{DB: db, Strategy: strategy, Size: workers}
+	return pool.Run(ctx), nil
+}
+
+// Convert a date from a BWB document to a value for a nullable date column.
+func nullDate(date string) interface{} {
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return nil
+	}
+	return date
+}
+
+// Read the snapshot with the given hash from r and record its validation
+// problems, toestand meta-data and references in tx. The document is
+// analysed while it streams past, so it is never held in memory as a tree.
+func AnalyseSnapshot(tx *sql.Tx, hash string, r io.Reader) error {
+	if _, err := tx.Exec("DELETE FROM bwb_references WHERE hash=$1", hash); err != nil {
 		return err
 	}
-	stmt, err := tx.Prepare("INSERT INTO bwb_documents " +
-		"(bwbid, officieletitel, titel, status, regelingsoort, startdatum, vervaldatum) " +
-		"VALUES ($1, $2, $3, $4, $5, $6, $7)")
-	if err != nil {
+	stream := NewBWBStream(r)
+	v := newValidator()
+	var parseErr error
+	for {
+		ev, err := stream.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			parseErr = err
+			break
+		}
+		v.event(ev, stream.Header.Vorm)
+		switch ev.Type {
+		case ArtikelNode:
+			err = storeReferences(tx, hash, stream.BWBID(), ev.Node.Artikel.Nr, ev.Node.Artikel.References())
+		case BijlageNode:
+			err = storeReferences(tx, hash, stream.BWBID(), bijlageKey(*ev.Bijlage), refsIn(ev.Bijlage.Blokken))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	header := stream.Header
+	header.BWBID = stream.BWBID()
+	problems := v.finish(&header)
+	if parseErr != nil {
+		problems = []ValidationProblem{{ParseError, "/", parseErr.Error()}}
+	}
+	if err := storeValidation(tx, hash, problems); err != nil {
 		return err
 	}
+	return storeToestand(tx, hash, header.Toestand)
+}
 
-	for i := range bwblist.RegelingInfoLijst {
-		var err error
-		if bwblist.RegelingInfoLijst[i].InwerkingtredingsDatum == "" {
-			if bwblist.RegelingInfoLijst[i].VervalDatum == "" {
-				_, err = stmt.Exec(bwblist.RegelingInfoLijst[i].BWBId,
-					bwblist.RegelingInfoLijst[i].OfficieleTitel,
-					bwblist.RegelingInfoLijst[i].Titel,
-					bwblist.RegelingInfoLijst[i].Status,
-					bwblist.RegelingInfoLijst[i].RegelingSoort,
-					nil,
-					nil)
-			} else {
-				_, err = stmt.Exec(bwblist.RegelingInfoLijst[i].BWBId,
-					bwblist.RegelingInfoLijst[i].OfficieleTitel,
-					bwblist.RegelingInfoLijst[i].Titel,
-					bwblist.RegelingInfoLijst[i].Status,
-					bwblist.RegelingInfoLijst[i].RegelingSoort,
-					nil,
-					bwblist.RegelingInfoLijst[i].VervalDatum)
-			}
-		} else {
-			if bwblist.RegelingInfoLijst[i].VervalDatum == "" {
-				_, err = stmt.Exec(bwblist.RegelingInfoLijst[i].BWBId,
-					bwblist.RegelingInfoLijst[i].OfficieleTitel,
-					bwblist.RegelingInfoLijst[i].Titel,
-					bwblist.RegelingInfoLijst[i].Status,
-					bwblist.RegelingInfoLijst[i].RegelingSoort,
-					bwblist.RegelingInfoLijst[i].InwerkingtredingsDatum,
-					nil)
-			} else {
-				_, err = stmt.Exec(bwblist.RegelingInfoLijst[i].BWBId,
-					bwblist.RegelingInfoLijst[i].OfficieleTitel,
-					bwblist.RegelingInfoLijst[i].Titel,
-					bwblist.RegelingInfoLijst[i].Status,
-					bwblist.RegelingInfoLijst[i].RegelingSoort,
-					bwblist.RegelingInfoLijst[i].InwerkingtredingsDatum,
-					bwblist.RegelingInfoLijst[i].VervalDatum)
-			}
-		}
+func storeValidation(tx *sql.Tx, hash string, problems []ValidationProblem) error {
+	if _, err := tx.Exec("DELETE FROM bwb_snapshot_problems WHERE hash=$1", hash); err != nil {
+		return err
+	}
+	for _, p := range problems {
+		_, err := tx.Exec("INSERT INTO bwb_snapshot_problems (hash, kind, path, message) VALUES ($1, $2, $3, $4)",
+			hash, p.Kind.String(), p.Path, p.Message)
 		if err != nil {
 			return err
 		}
 	}
-	err = tx.Commit()
+	_, err := tx.Exec("UPDATE bwb_snapshots SET valid=$2 WHERE hash=$1", hash, len(problems) == 0)
+	return err
+}
+
+func storeToestand(tx *sql.Tx, hash string, toestand ToestandType) error {
+	_, err := tx.Exec("UPDATE bwb_snapshots SET inwerkingtreding=$2, geldig_van=$3, geldig_tot=$4 WHERE hash=$1",
+		hash, nullDate(toestand.Inwerkingtreding), nullDate(toestand.GeldigVan), nullDate(toestand.GeldigTot))
 	if err != nil {
 		return err
 	}
+	if _, err = tx.Exec("DELETE FROM bwb_snapshot_bronnen WHERE hash=$1", hash); err != nil {
+		return err
+	}
+	for _, b := range toestand.Bronnen {
+		_, err = tx.Exec("INSERT INTO bwb_snapshot_bronnen (hash, soort, effect, jaargang, nummer, uitgiftedatum) VALUES ($1, $2, $3, $4, $5, $6)",
+			hash, b.Soort, b.Effect, b.Jaargang, b.Nummer, nullDate(b.Uitgiftedatum))
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func getbwburl(bwbid string, date time.Time) string {
-	v := url.Values{}
-	v.Set("regelingID", bwbid)
-	v.Set("geldigheidsdatum", fmt.Sprintf("%02d-%02d-%04d", date.Day(), date.Month(), date.Year()))
-	return "http://wetten.overheid.nl/xml.php?" + v.Encode()
-}
+// The fifth of may 2002 is the smallest date the API will return results for
+var firstSnapshotDate = time.Date(2002, time.May, 1, 12, 0, 0, 0, time.UTC)
 
-func keepBWBSynced(connectionURL string) {
+// A version of a regeling as returned by SnapshotPath on a given date.
+type probe struct {
+	date    time.Time
+	hash    string
+	content []byte
+}
 
-	// Connect to the database
-	db, err := sql.Open("postgres", connectionURL)
-	if err != nil {
-		log.Println(err)
-		return
+// Download the version of bwbid that is valid on date.
+func fetchSnapshot(ctx context.Context, fetcher Fetcher, bwbid string, date time.Time) (probe, error) {
+	p := probe{date: date}
+	var err error
+	p.content, err = fetchAll(ctx, fetcher, SnapshotPath, getbwbquery(bwbid, date))
+	if err == ErrNotFound {
+		return p, nil // No version yet
+	} else if err != nil {
+		return p, fmt.Errorf("%s %s: %v", bwbid, SimpleTimeFmt(date), err)
 	}
+	p.hash = hashContent(p.content)
+	return p, nil
+}
 
-	bwbs := make(chan string)
-	go ConcurrentSyncer(db, 8, bwbs)
-	rows, err := db.Query("SELECT bwbid FROM bwb_documents WHERE regelingsoort='wet'")
+// Insert a new version unless a snapshot with the same hash exists. The
+// snapshot and its analysis are stored together, so a failed analysis is
+// retried by the next sync instead of leaving an unanalysed snapshot.
+func storeSnapshot(db *sql.DB, bwbid string, p probe) error {
+	tx, err := db.Begin()
 	if err != nil {
-		log.Println(err)
-		return
+		return err
 	}
-	for rows.Next() {
-		var bwbid string
-		if err := rows.Scan(&bwbid); err != nil {
-			log.Println(err)
-		}
-		/*if bwbid == "BWBR0011468" || bwbid == "BWBR0013409" || bwbid == "BWBR0009950" || bwbid == "BWBR0001854" || bwbid == "BWBR0005537" || bwbid == "BWBR0001840" || bwbid == "BWBR0001886" || bwbid == "BWBR0024282" || bwbid == "BWBR0020368‎" {
-			go LoadBWBSnapshots(db, bwbid)
-		}*/
-		bwbs <- bwbid
+	defer tx.Rollback()
+	var resulthash string
+	err = tx.QueryRow("SELECT hash FROM bwb_snapshots WHERE hash=$1", p.hash).Scan(&resulthash)
+	if err != sql.ErrNoRows {
+		return err // Skip insertion if hash already exists
+	}
+	log.Println("NEW VERSION", bwbid, p.date)
+	_, err = tx.Exec(`INSERT INTO bwb_snapshots
+		(hash, bwbid, pubdate, content, hashversion)
+		VALUES
+		($1, $2, $3, $4, $5);`, p.hash, bwbid, p.date, string(p.content), hashVersion)
+	if err != nil {
+		return err
 	}
-	if err := rows.Err(); err != nil {
-		log.Println(err)
+	if err = AnalyseSnapshot(tx, p.hash, bytes.NewReader(p.content)); err != nil {
+		return err
 	}
+	return tx.Commit()
+}
 
+func daysBetween(from, to time.Time) int {
+	return int(to.Sub(from).Hours() / 24)
 }
 
-func ConcurrentSyncer(db *sql.DB, n int, bwbs chan string) {
-	done := make(chan bool)
-	for i := 0; i < n; i++ {
-		go LoadBWBSnapshots(db, <-bwbs, done)
+// Find the first day of every version between the known versions from and
+// to by bisection, and pass them to store. Each version costs a logarithmic number
+// of requests in the length of the interval. A version that is replaced by
+// the version it replaced before both probes cannot be seen.
+func bisectVersions(ctx context.Context, fetcher Fetcher, bwbid string, from, to probe, store func(probe) error) error {
+	for from.hash != to.hash {
+		lo, hi := from, to
+		for daysBetween(lo.date, hi.date) > 1 {
+			mid, err := fetchSnapshot(ctx, fetcher, bwbid, lo.date.AddDate(0, 0, daysBetween(lo.date, hi.date)/2))
+			if err != nil {
+				return err
+			}
+			if mid.hash == from.hash {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+		// hi is the first day of a new version, or of a gap without one
+		if hi.hash != "" {
+			if err := store(hi); err != nil {
+				return err
+			}
+		}
+		from = hi
 	}
-	for bwbid := range bwbs {
-		_ = <-done
-		go LoadBWBSnapshots(db, bwbid, done)
+	return nil
+}
+
+// A way of finding and downloading all versions of a regeling.
+type SyncStrategy interface {
+	Sync(ctx context.Context, db *sql.DB, bwbid string) error
+}
+
+// Returns the strategy with the given name: probe, which fetches from
+// source, or manifest, which fetches from repository.
+func NewSyncStrategy(name string, source, repository Fetcher) (SyncStrategy, error) {
+	switch name {
+	case "probe":
+		return ProbeStrategy{source}, nil
+	case "manifest":
+		return ManifestStrategy{repository}, nil
 	}
+	return nil, fmt.Errorf("unknown sync strategy %q", name)
+}
 
+// Finds versions by probing SnapshotPath with different geldigheidsdatum
+// values.
+type ProbeStrategy struct {
+	Fetcher Fetcher
 }
 
-func LoadBWBSnapshots(db *sql.DB, bwbid string, done chan bool) {
-	// Get the starting date to scrape from
-	var date time.Time
-	err := db.QueryRow("SELECT pubdate FROM bwb_snapshots WHERE bwbid=$1 ORDER BY pubdate DESC LIMIT 1", bwbid).Scan(&date)
-	if err == sql.ErrNoRows {
-		// The fifth of may 2002 is the smallest date the API will return results for
-		date = time.Date(2002, time.May, 1, 12, 0, 0, 0, time.UTC)
-	} else if err != nil {
-		log.Println(err)
+func (s ProbeStrategy) Sync(ctx context.Context, db *sql.DB, bwbid string) error {
+	return syncDocument(ctx, db, s.Fetcher, bwbid)
+}
+
+func LoadBWBSnapshots(ctx context.Context, db *sql.DB, strategy SyncStrategy, bwbid string) error {
+	start := time.Now()
+	if err := strategy.Sync(ctx, db, bwbid); err != nil {
+		return err
 	}
-	if date.Before(time.Date(2002, time.May, 1, 12, 0, 0, 0, time.UTC)) {
-		date = time.Date(2002, time.May, 1, 12, 0, 0, 0, time.UTC)
+	if _, err := db.Exec("UPDATE bwb_documents SET laatstesync=$2 WHERE bwbid=$1", bwbid, start); err != nil {
+		return err
 	}
+	log.Println("Sync of", bwbid, "complete.")
+	return nil
+}
 
-	largestep := 2 * 31 * 24 * time.Hour // Two months
-	smallstep := 24 * time.Hour          // One day
-	timestep := largestep
-	insertmode := false // Seeking for new versions with 1 month increments, insert with 1 day increments.
-	for now := time.Now(); date.Before(now); date = date.Add(timestep) {
-		url := getbwburl(bwbid, date)
-		//log.Println(url)
-		resp, err := http.Get(url)
-		if err != nil {
-			log.Println(err)
-			break
+func syncDocument(ctx context.Context, db *sql.DB, fetcher Fetcher, bwbid string) error {
+	// Start from the newest version we know of
+	last := probe{}
+	err := db.QueryRow("SELECT pubdate, hash FROM bwb_snapshots WHERE bwbid=$1 ORDER BY pubdate DESC LIMIT 1", bwbid).Scan(&last.date, &last.hash)
+	if err == sql.ErrNoRows || (err == nil && last.date.Before(firstSnapshotDate)) {
+		// Or from the day the regeling came into force
+		last = probe{}
+		err = db.QueryRow("SELECT COALESCE(startdatum, $2) FROM bwb_documents WHERE bwbid=$1", bwbid, firstSnapshotDate).Scan(&last.date)
+		if err != nil && err != sql.ErrNoRows {
+			return err
 		}
-		if resp.StatusCode != 200 {
-			log.Println(resp.Status)
-			break
+	} else if err != nil {
+		return err
+	}
+	return probeVersions(ctx, fetcher, bwbid, last, time.Now(), func(p probe) error {
+		return storeSnapshot(db, bwbid, p)
+	})
+}
+
+// Find the versions of bwbid that appeared after the version last and until
+// now, and pass them to store. If last has no hash, probing starts on its
+// date, or on firstSnapshotDate if that is later, and the version found
+// there is passed to store first. Dates without a version are skipped.
+func probeVersions(ctx context.Context, fetcher Fetcher, bwbid string, last probe, now time.Time, store func(probe) error) error {
+	last.date = time.Date(last.date.Year(), last.date.Month(), last.date.Day(), 12, 0, 0, 0, time.UTC)
+	if last.hash == "" {
+		if last.date.Before(firstSnapshotDate) {
+			last.date = firstSnapshotDate
 		}
-		content, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Println(err)
-			break
+		var err error
+		if last, err = fetchSnapshot(ctx, fetcher, bwbid, last.date); err != nil {
+			return err
 		}
-		resp.Body.Close()
-		h := sha256.New()
-		io.WriteString(h, string(content))
-		hash := fmt.Sprintf("%x", h.Sum(nil))
-		var resulthash string
-		err = db.QueryRow("SELECT hash FROM bwb_snapshots WHERE hash=$1", hash).Scan(&resulthash)
-		switch {
-		case err == sql.ErrNoRows:
-			if insertmode {
-				log.Println("NEW VERSION", bwbid, date)
-				_, err = db.Exec(`INSERT INTO bwb_snapshots 
-					(hash, bwbid, pubdate, content) 
-					VALUES
-					($1, $2, $3, $4);`, hash, bwbid, date, string(content))
-				if err != nil {
-					log.Println(err)
-				}
-				timestep = largestep
-				insertmode = false
-			} else {
-				timestep = smallstep
-				date.Add(-largestep)
-				insertmode = true
+		if last.hash != "" {
+			if err = store(last); err != nil {
+				return err
 			}
-		case err != nil:
-			log.Println(err)
-		default:
-			// Skip insertion if hash allready exists
-			// log.Println(bwbid, date)
 		}
+	}
 
+	// Probe in large steps and bisect whenever the version changed
+	largestep := 2 * 31 // Two months, in days
+	for last.date.Before(now) {
+		date := last.date.AddDate(0, 0, largestep)
+		if date.After(now) {
+			date = time.Date(now.Year(), now.Month(), now.Day(), 12, 0, 0, 0, time.UTC)
+			if !date.After(last.date) {
+				break
+			}
+		}
+		next, err := fetchSnapshot(ctx, fetcher, bwbid, date)
+		if err != nil {
+			return err
+		}
+		if err = bisectVersions(ctx, fetcher, bwbid, last, next, store); err != nil {
+			return err
+		}
+		last = next
 	}
-	log.Println("Sync of", bwbid, "complete.")
-	done <- true
+	return nil
 }
@@ -0,0 +1,206 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"context"
+	"database/sql"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A fetcher for the fixtures in testdata, served over HTTP the way
+// wetten.overheid.nl serves them. Call the returned function when done.
+func fixtureFetcher() (HTTPFetcher, func()) {
+	server := httptest.NewServer(NewFixtureServer("testdata"))
+	return HTTPFetcher{server.URL, NewPoliteClient(0, 10*time.Second, 0)}, server.Close
+}
+
+// Connect to the database named by WETSGESCHIEDENIS_TEST_DB, or skip the
+// test if it is not set. The database is reset, so never point it at a
+// database with data you want to keep.
+func testDatabase(t *testing.T) (*sql.DB, string) {
+	connectionURL := os.Getenv("WETSGESCHIEDENIS_TEST_DB")
+	if connectionURL == "" {
+		t.Skip("WETSGESCHIEDENIS_TEST_DB is not set")
+	}
+	if err := ResetDatabase(connectionURL); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("postgres", connectionURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, connectionURL
+}
+
+// The fixtures hold three versions of the Awb, and a download of the second
+// version that only differs in its generation time and layout.
+var fixtureVersions = []string{"2002-05-01", "2010-03-15", "2015-07-01"}
+
+func TestProbeVersions(t *testing.T) {
+	fetcher, stop := fixtureFetcher()
+	defer stop()
+
+	found := []probe{}
+	store := func(p probe) error {
+		found = append(found, p)
+		return nil
+	}
+	now := time.Date(2016, time.January, 1, 12, 0, 0, 0, time.UTC)
+	if err := probeVersions(context.Background(), fetcher, "BWBR0005537", probe{}, now, store); err != nil {
+		t.Fatal(err)
+	}
+	dates := []string{}
+	for _, p := range found {
+		dates = append(dates, p.date.Format(JCIDateFmt))
+	}
+	if !reflect.DeepEqual(dates, fixtureVersions) {
+		t.Fatalf("found versions %v, want %v", dates, fixtureVersions)
+	}
+
+	// Continuing from the newest version finds nothing new
+	last := found[len(found)-1]
+	found = nil
+	if err := probeVersions(context.Background(), fetcher, "BWBR0005537", last, now.AddDate(1, 0, 0), store); err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 {
+		t.Errorf("found %d versions after the newest one", len(found))
+	}
+
+	// The first version of a newer regeling is found by bisecting from the
+	// last date it did not exist, whether probing starts in 2002 or on the
+	// day it came into force
+	for _, start := range []time.Time{{}, time.Date(2016, time.May, 1, 0, 0, 0, 0, time.UTC)} {
+		found = nil
+		if err := probeVersions(context.Background(), fetcher, "BWBR0037885", probe{date: start}, now.AddDate(1, 0, 0), store); err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 1 || found[0].date.Format(JCIDateFmt) != "2016-05-01" {
+			t.Errorf("starting on %s found %v, want the version of 2016-05-01", start.Format(JCIDateFmt), found)
+		}
+	}
+
+	// A regeling without fixtures has no versions
+	found = nil
+	if err := probeVersions(context.Background(), fetcher, "BWBR0001840", probe{}, now, store); err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 {
+		t.Errorf("found %d versions of a regeling that does not exist", len(found))
+	}
+}
+
+func TestProbeStrategy(t *testing.T) {
+	db, connectionURL := testDatabase(t)
+	defer db.Close()
+	fetcher, stop := fixtureFetcher()
+	defer stop()
+
+	if err := StoreBWBIdList(context.Background(), connectionURL, fetcher); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		// The second sync starts from the stored versions and adds none
+		if err := (ProbeStrategy{fetcher}).Sync(context.Background(), db, "BWBR0005537"); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := db.Query("SELECT pubdate, valid FROM bwb_snapshots WHERE bwbid=$1 ORDER BY pubdate", "BWBR0005537")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dates := []string{}
+		for rows.Next() {
+			var pubdate time.Time
+			var valid bool
+			if err := rows.Scan(&pubdate, &valid); err != nil {
+				t.Fatal(err)
+			}
+			if !valid {
+				t.Errorf("version %s is not valid", pubdate.Format(JCIDateFmt))
+			}
+			dates = append(dates, pubdate.Format(JCIDateFmt))
+		}
+		rows.Close()
+		if !reflect.DeepEqual(dates, fixtureVersions) {
+			t.Fatalf("sync %d stored versions %v, want %v", i+1, dates, fixtureVersions)
+		}
+	}
+
+	// A regeling that came into force later is synced from its startdatum
+	if err := (ProbeStrategy{fetcher}).Sync(context.Background(), db, "BWBR0037885"); err != nil {
+		t.Fatal(err)
+	}
+	var versions int
+	if err := db.QueryRow("SELECT COUNT(*) FROM bwb_snapshots WHERE bwbid=$1", "BWBR0037885").Scan(&versions); err != nil {
+		t.Fatal(err)
+	}
+	if versions != 1 {
+		t.Errorf("stored %d versions of the Omgevingswet, want 1", versions)
+	}
+
+	// The reference in artikel 1:2 of every version was stored
+	var references int
+	err := db.QueryRow(`SELECT COUNT(*) FROM bwb_references
+		WHERE source_artikel='1:2' AND target_bwbid='BWBR0005537' AND target_artikel='1:1'`).Scan(&references)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if references != len(fixtureVersions) {
+		t.Errorf("stored %d references, want %d", references, len(fixtureVersions))
+	}
+}
+
+func TestOnlyChanged(t *testing.T) {
+	db, connectionURL := testDatabase(t)
+	defer db.Close()
+	fetcher, stop := fixtureFetcher()
+	defer stop()
+
+	if err := StoreBWBIdList(context.Background(), connectionURL, fetcher); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadBWBSnapshots(context.Background(), db, ProbeStrategy{fetcher}, "BWBR0005537"); err != nil {
+		t.Fatal(err)
+	}
+	filter := SyncFilter{BWBIds: []string{"BWBR0005537"}, OnlyChanged: true}
+	where, args := filter.where()
+	for _, test := range []struct {
+		laatstewijziging string
+		selected         bool
+	}{
+		{"2015-07-01", false},
+		// A change announced before it takes effect is looked for until the
+		// version appears, however often we sync in between
+		{"2099-01-01", true},
+	} {
+		if _, err := db.Exec("UPDATE bwb_documents SET laatstewijziging=$1 WHERE bwbid='BWBR0005537'", test.laatstewijziging); err != nil {
+			t.Fatal(err)
+		}
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM bwb_documents WHERE "+where, args...).Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		if selected := count == 1; selected != test.selected {
+			t.Errorf("with laatstewijziging %s the Awb is selected: %v, want %v", test.laatstewijziging, selected, test.selected)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"github.com/lib/pq"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// Number of rows sent to the database in one COPY.
+const copyBatchSize = 5000
+
+// Download the BWBIdList zip to a temporary file. The caller should remove
+// the file when done.
+func DownloadBWBIdList(ctx context.Context, fetcher Fetcher) (string, error) {
+	log.Println("Downloading BWBIdList zip.")
+	file, err := ioutil.TempFile("", "BWBIdList-*.xml.zip")
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	if err = fetchFile(ctx, fetcher, BWBIdListPath, nil, file); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// Decodes the RegelingInfo elements of a BWBIdList zip one at a time.
+type BWBIdListStream struct {
+	GegenereerdOp string // Set once the element has been read
+	zip           *zip.ReadCloser
+	xml           io.ReadCloser
+	decoder       *xml.Decoder
+}
+
+func OpenBWBIdList(name string) (*BWBIdListStream, error) {
+	z, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, fmt.Errorf("BWBIdList is not a zip file: %v", err)
+	}
+	for _, f := range z.File {
+		if f.Name == "BWBIdList.xml" {
+			reader, err := f.Open()
+			if err != nil {
+				z.Close()
+				return nil, err
+			}
+			return &BWBIdListStream{zip: z, xml: reader, decoder: xml.NewDecoder(reader)}, nil
+		}
+	}
+	z.Close()
+	return nil, fmt.Errorf("BWBIdList.xml not found in %s", name)
+}
+
+// The next regeling in the list, or io.EOF after the last one.
+func (s *BWBIdListStream) Next() (*RegelingInfo, error) {
+	for {
+		token, err := s.decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "GegenereerdOp":
+			if err := s.decoder.DecodeElement(&s.GegenereerdOp, &start); err != nil {
+				return nil, err
+			}
+		case "RegelingInfo":
+			info := new(RegelingInfo)
+			if err := s.decoder.DecodeElement(info, &start); err != nil {
+				return nil, err
+			}
+			return info, nil
+		}
+	}
+}
+
+func (s *BWBIdListStream) Close() error {
+	s.xml.Close()
+	return s.zip.Close()
+}
+
+// Rows for a COPY into table. Rows are sent in batches, so several tables can
+// be filled at the same time while only one COPY is active on the
+// transaction.
+type copyBatch struct {
+	tx      *sql.Tx
+	table   string
+	columns []string
+	rows    [][]interface{}
+}
+
+func (b *copyBatch) add(row ...interface{}) error {
+	b.rows = append(b.rows, row)
+	if len(b.rows) >= copyBatchSize {
+		return b.flush()
+	}
+	return nil
+}
+
+func (b *copyBatch) flush() error {
+	if len(b.rows) == 0 {
+		return nil
+	}
+	stmt, err := b.tx.Prepare(pq.CopyIn(b.table, b.columns...))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, row := range b.rows {
+		if _, err = stmt.Exec(row...); err != nil {
+			return err
+		}
+	}
+	if _, err = stmt.Exec(); err != nil {
+		return err
+	}
+	b.rows = b.rows[:0]
+	return nil
+}
+
+// Temporary tables holding a BWBIdList while it is being loaded.
+type bwbIdListStaging struct {
+	documents           *copyBatch
+	citeertitels        *copyBatch
+	afkortingen         *copyBatch
+	nietOfficieleTitels *copyBatch
+	verantwoordelijken  *copyBatch
+	euRichtlijnen       *copyBatch
+}
+
+func newBWBIdListStaging(tx *sql.Tx) (*bwbIdListStaging, error) {
+	_, err := tx.Exec(`CREATE TEMP TABLE staging_documents
+		(
+			bwbid				character varying(32)	PRIMARY KEY,
+			officieletitel		character varying(2048)	NOT NULL,
+			titel				character varying(1024)	NOT NULL,
+			status				character varying(64)	NOT NULL,
+			regelingsoort		character varying(64)	NOT NULL,
+			startdatum			date					NULL,
+			vervaldatum			date					NULL,
+			laatstewijziging	date					NULL
+		) ON COMMIT DROP`)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range regelingNaamTables {
+		if _, err = tx.Exec("CREATE TEMP TABLE staging_" + table + " (LIKE " + table + ") ON COMMIT DROP"); err != nil {
+			return nil, err
+		}
+	}
+	return &bwbIdListStaging{
+		documents: &copyBatch{tx: tx, table: "staging_documents", columns: []string{
+			"bwbid", "officieletitel", "titel", "status", "regelingsoort", "startdatum", "vervaldatum", "laatstewijziging"}},
+		citeertitels:        &copyBatch{tx: tx, table: "staging_bwb_citeertitels", columns: []string{"bwbid", "titel", "status", "inwerkingtreding"}},
+		afkortingen:         &copyBatch{tx: tx, table: "staging_bwb_afkortingen", columns: []string{"bwbid", "afkorting"}},
+		nietOfficieleTitels: &copyBatch{tx: tx, table: "staging_bwb_niet_officiele_titels", columns: []string{"bwbid", "titel"}},
+		verantwoordelijken:  &copyBatch{tx: tx, table: "staging_bwb_verantwoordelijken", columns: []string{"bwbid", "organisatie"}},
+		euRichtlijnen:       &copyBatch{tx: tx, table: "staging_bwb_eu_richtlijnen", columns: []string{"bwbid", "richtlijn"}},
+	}, nil
+}
+
+func (s *bwbIdListStaging) add(info *RegelingInfo) error {
+	c := info.Citeertitel()
+	err := s.documents.add(info.BWBId, info.OfficieleTitel, c.Titel, c.Status, info.RegelingSoort,
+		nullDate(c.InwerkingtredingsDatum), nullDate(info.VervalDatum), nullDate(info.DatumLaatsteWijziging))
+	if err != nil {
+		return err
+	}
+	return s.addNamen(info)
+}
+
+func (s *bwbIdListStaging) flush() error {
+	for _, b := range []*copyBatch{s.documents, s.citeertitels, s.afkortingen, s.nietOfficieleTitels, s.verantwoordelijken, s.euRichtlijnen} {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Download the BWBIdList and insert or update its regelingen in bwb_documents.
+// Every changed field of a known regeling is recorded in bwb_documents_history.
+func StoreBWBIdList(ctx context.Context, connectionURL string, fetcher Fetcher) error {
+	// Connect to the database
+	db, err := sql.Open("postgres", connectionURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	name, err := DownloadBWBIdList(ctx, fetcher)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(name)
+	stream, err := OpenBWBIdList(name)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	log.Println("Filling bwb_documents table")
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	staging, err := newBWBIdListStaging(tx)
+	if err != nil {
+		return err
+	}
+	// The same regeling may occur twice in one list
+	seen := make(map[string]bool)
+	for {
+		info, err := stream.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if seen[info.BWBId] {
+			continue
+		}
+		seen[info.BWBId] = true
+		if err = staging.add(info); err != nil {
+			return err
+		}
+	}
+	if err = staging.flush(); err != nil {
+		return err
+	}
+
+	list, err := newBWBList(tx, stream.GegenereerdOp)
+	if err == errListLoaded {
+		log.Println("BWBIdList generated on", stream.GegenereerdOp, "was already loaded.")
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	// Record the changed fields of known regelingen
+	_, err = tx.Exec(`INSERT INTO bwb_documents_history (bwbid, field, old_value, new_value, changed, list_id)
+		SELECT s.bwbid, f.field, f.old_value, f.new_value, $1, $2
+		FROM staging_documents s
+		JOIN bwb_documents d ON d.bwbid = s.bwbid
+		CROSS JOIN LATERAL (VALUES
+			('officieletitel', d.officieletitel::text, s.officieletitel::text),
+			('titel', d.titel, s.titel),
+			('status', d.status, s.status),
+			('regelingsoort', d.regelingsoort, s.regelingsoort),
+			('startdatum', to_char(d.startdatum, 'YYYY-MM-DD'), to_char(s.startdatum, 'YYYY-MM-DD')),
+			('vervaldatum', to_char(d.vervaldatum, 'YYYY-MM-DD'), to_char(s.vervaldatum, 'YYYY-MM-DD')),
+			('laatstewijziging', to_char(d.laatstewijziging, 'YYYY-MM-DD'), to_char(s.laatstewijziging, 'YYYY-MM-DD'))
+		) AS f(field, old_value, new_value)
+		WHERE f.old_value IS DISTINCT FROM f.new_value`, time.Now(), list.ID)
+	if err != nil {
+		return err
+	}
+	added, changed, err := list.recordChanges(tx)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO bwb_documents
+		(bwbid, officieletitel, titel, status, regelingsoort, startdatum, vervaldatum, laatstewijziging)
+		SELECT bwbid, officieletitel, titel, status, regelingsoort, startdatum, vervaldatum, laatstewijziging
+		FROM staging_documents
+		ON CONFLICT (bwbid) DO UPDATE SET officieletitel=EXCLUDED.officieletitel, titel=EXCLUDED.titel,
+		status=EXCLUDED.status, regelingsoort=EXCLUDED.regelingsoort, startdatum=EXCLUDED.startdatum,
+		vervaldatum=EXCLUDED.vervaldatum, laatstewijziging=EXCLUDED.laatstewijziging
+		WHERE (bwb_documents.officieletitel, bwb_documents.titel, bwb_documents.status, bwb_documents.regelingsoort,
+			bwb_documents.startdatum, bwb_documents.vervaldatum, bwb_documents.laatstewijziging)
+		IS DISTINCT FROM (EXCLUDED.officieletitel, EXCLUDED.titel, EXCLUDED.status, EXCLUDED.regelingsoort,
+			EXCLUDED.startdatum, EXCLUDED.vervaldatum, EXCLUDED.laatstewijziging)`)
+	if err != nil {
+		return err
+	}
+	removed, err := list.finish(tx, len(seen))
+	if err != nil {
+		return err
+	}
+	if err = storeRegelingNamen(tx); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	log.Println("BWBIdList loaded:", len(seen), "regelingen,", added, "added,", changed, "changed,", removed, "removed.")
+	return nil
+}
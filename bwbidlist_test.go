@@ -0,0 +1,100 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"context"
+	"io"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDownloadBWBIdList(t *testing.T) {
+	fetcher, stop := fixtureFetcher()
+	defer stop()
+
+	name, err := DownloadBWBIdList(context.Background(), fetcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(name)
+	list, err := OpenBWBIdList(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer list.Close()
+	ids := []string{}
+	for {
+		info, err := list.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, info.BWBId)
+		if info.BWBId == "BWBR0005537" && !reflect.DeepEqual(info.Afkortingen, []string{"Awb"}) {
+			t.Errorf("afkortingen of the Awb are %v", info.Afkortingen)
+		}
+	}
+	if want := []string{"BWBR0005537", "BWBR0037885", "BWBR0001840"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got regelingen %v, want %v", ids, want)
+	}
+	if list.GegenereerdOp != "2014-03-12T08:00:00" {
+		t.Errorf("GegenereerdOp is %q", list.GegenereerdOp)
+	}
+
+	// A missing list is reported as not found
+	if _, err := DownloadBWBIdList(context.Background(), DirFetcher{t.TempDir()}); err != ErrNotFound {
+		t.Errorf("got %v for a missing list, want ErrNotFound", err)
+	}
+}
+
+func TestStoreBWBIdList(t *testing.T) {
+	db, connectionURL := testDatabase(t)
+	defer db.Close()
+	fetcher, stop := fixtureFetcher()
+	defer stop()
+
+	if err := StoreBWBIdList(context.Background(), connectionURL, fetcher); err != nil {
+		t.Fatal(err)
+	}
+	var documents, regelingen int
+	if err := db.QueryRow("SELECT COUNT(*) FROM bwb_documents").Scan(&documents); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("SELECT regelingen FROM bwb_lists ORDER BY id DESC LIMIT 1").Scan(&regelingen); err != nil {
+		t.Fatal(err)
+	}
+	if documents != 3 || regelingen != 3 {
+		t.Errorf("stored %d documents from a list of %d regelingen, want 3", documents, regelingen)
+	}
+	var bwbid string
+	if err := db.QueryRow("SELECT bwbid FROM bwb_afkortingen WHERE afkorting='Awb'").Scan(&bwbid); err != nil {
+		t.Fatal(err)
+	}
+	if bwbid != "BWBR0005537" {
+		t.Errorf("Awb is the afkorting of %s", bwbid)
+	}
+	var startdatum string
+	if err := db.QueryRow("SELECT to_char(startdatum, 'YYYY-MM-DD') FROM bwb_documents WHERE bwbid='BWBR0037885'").Scan(&startdatum); err != nil {
+		t.Fatal(err)
+	}
+	if startdatum != "2016-05-01" {
+		t.Errorf("startdatum of the Omgevingswet is %s, want 2016-05-01", startdatum)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	_ "github.com/lib/pq"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How a regeling changed between two generations of the BWBIdList
+const (
+	ListAdded   = "added"
+	ListRemoved = "removed"
+	ListChanged = "changed"
+)
+
+var errListLoaded = errors.New("BWBIdList generation already loaded")
+
+// A generation of the BWBIdList, as recorded in bwb_lists.
+type BWBList struct {
+	ID            int64
+	GegenereerdOp sql.NullString
+	Downloaded    time.Time
+	Regelingen    int
+	Previous      int64 // The generation loaded before this one, 0 if none
+}
+
+// Record a new generation of the BWBIdList. Returns errListLoaded if a list
+// with the same GegenereerdOp was loaded before.
+func newBWBList(tx *sql.Tx, gegenereerdOp string) (*BWBList, error) {
+	list := &BWBList{
+		GegenereerdOp: nullString(gegenereerdOp),
+		Downloaded:    time.Now(),
+	}
+	if list.GegenereerdOp.Valid {
+		var exists bool
+		err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM bwb_lists WHERE gegenereerdop=$1)", list.GegenereerdOp).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, errListLoaded
+		}
+	}
+	err := tx.QueryRow("SELECT COALESCE(MAX(id), 0) FROM bwb_lists").Scan(&list.Previous)
+	if err != nil {
+		return nil, err
+	}
+	err = tx.QueryRow("INSERT INTO bwb_lists (gegenereerdop, downloaded, regelingen) VALUES ($1, $2, 0) RETURNING id",
+		list.GegenereerdOp, list.Downloaded).Scan(&list.ID)
+	return list, err
+}
+
+// Record the regelingen in staging_documents that are new, or that return
+// after being removed from an earlier list, and those whose fields changed.
+// Must be called after the history is written and before bwb_documents is
+// updated.
+func (list *BWBList) recordChanges(tx *sql.Tx) (added, changed int64, err error) {
+	result, err := tx.Exec(`INSERT INTO bwb_list_changes (list_id, bwbid, change)
+		SELECT $1, s.bwbid, $2 FROM staging_documents s
+		LEFT JOIN bwb_documents d ON d.bwbid = s.bwbid
+		WHERE d.bwbid IS NULL OR ($3 <> 0 AND d.laatstelijst IS DISTINCT FROM $3)`,
+		list.ID, ListAdded, list.Previous)
+	if err != nil {
+		return 0, 0, err
+	}
+	if added, err = result.RowsAffected(); err != nil {
+		return 0, 0, err
+	}
+	result, err = tx.Exec(`INSERT INTO bwb_list_changes (list_id, bwbid, change)
+		SELECT DISTINCT $1, bwbid, $2 FROM bwb_documents_history
+		WHERE list_id=$1 AND bwbid NOT IN (SELECT bwbid FROM bwb_list_changes WHERE list_id=$1)`,
+		list.ID, ListChanged)
+	if err != nil {
+		return 0, 0, err
+	}
+	changed, err = result.RowsAffected()
+	return added, changed, err
+}
+
+// Mark the regelingen in staging_documents as part of this generation, and
+// record the regelingen of the previous generation that are missing as
+// removed.
+func (list *BWBList) finish(tx *sql.Tx, regelingen int) (int64, error) {
+	list.Regelingen = regelingen
+	_, err := tx.Exec("UPDATE bwb_lists SET regelingen=$2 WHERE id=$1", list.ID, regelingen)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec(`UPDATE bwb_documents d SET laatstelijst=$1
+		FROM staging_documents s WHERE d.bwbid = s.bwbid`, list.ID)
+	if err != nil {
+		return 0, err
+	}
+	if list.Previous == 0 {
+		return 0, nil
+	}
+	result, err := tx.Exec(`INSERT INTO bwb_list_changes (list_id, bwbid, change)
+		SELECT $1, bwbid, $2 FROM bwb_documents WHERE laatstelijst=$3`, list.ID, ListRemoved, list.Previous)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// A field of a regeling that changed between two generations.
+type FieldChange struct {
+	Field string
+	Old   sql.NullString
+	New   sql.NullString
+}
+
+type ListChange struct {
+	BWBID  string
+	Titel  string
+	Change string
+	Fields []FieldChange
+}
+
+// The regelingen that were added, removed or changed in a generation of the
+// BWBIdList compared to the one before.
+type ListReport struct {
+	List    BWBList
+	Lists   []BWBList // All generations, newest first
+	Added   []ListChange
+	Removed []ListChange
+	Changed []ListChange
+}
+
+// All generations of the BWBIdList, newest first.
+func BWBLists(db *sql.DB) ([]BWBList, error) {
+	rows, err := db.Query(`SELECT id, to_char(gegenereerdop, 'DD-MM-YYYY HH24:MI'), downloaded, regelingen,
+		COALESCE((SELECT MAX(p.id) FROM bwb_lists p WHERE p.id < l.id), 0)
+		FROM bwb_lists l ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	lists := []BWBList{}
+	for rows.Next() {
+		list := BWBList{}
+		if err := rows.Scan(&list.ID, &list.GegenereerdOp, &list.Downloaded, &list.Regelingen, &list.Previous); err != nil {
+			return lists, err
+		}
+		lists = append(lists, list)
+	}
+	return lists, rows.Err()
+}
+
+// The report for the generation with the given id, or for the newest
+// generation if id is 0. Returns sql.ErrNoRows if there is no such list.
+func BWBListReport(db *sql.DB, id int64) (*ListReport, error) {
+	lists, err := BWBLists(db)
+	if err != nil {
+		return nil, err
+	}
+	report := &ListReport{Lists: lists}
+	found := false
+	for _, list := range lists {
+		if id == 0 || list.ID == id {
+			report.List = list
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, sql.ErrNoRows
+	}
+
+	rows, err := db.Query(`SELECT c.bwbid, d.titel, c.change FROM bwb_list_changes c
+		JOIN bwb_documents d ON d.bwbid = c.bwbid
+		WHERE c.list_id=$1 ORDER BY d.titel, c.bwbid`, report.List.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changed := make(map[string]int)
+	for rows.Next() {
+		change := ListChange{}
+		if err := rows.Scan(&change.BWBID, &change.Titel, &change.Change); err != nil {
+			return nil, err
+		}
+		switch change.Change {
+		case ListAdded:
+			report.Added = append(report.Added, change)
+		case ListRemoved:
+			report.Removed = append(report.Removed, change)
+		case ListChanged:
+			changed[change.BWBID] = len(report.Changed)
+			report.Changed = append(report.Changed, change)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query("SELECT bwbid, field, old_value, new_value FROM bwb_documents_history WHERE list_id=$1", report.List.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bwbid string
+		field := FieldChange{}
+		if err := rows.Scan(&bwbid, &field.Field, &field.Old, &field.New); err != nil {
+			return nil, err
+		}
+		if i, ok := changed[bwbid]; ok {
+			report.Changed[i].Fields = append(report.Changed[i].Fields, field)
+		}
+	}
+	return report, rows.Err()
+}
+
+// Write the report as plain text.
+func (report *ListReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "BWBIdList %d, gegenereerd op %s, %d regelingen\n",
+		report.List.ID, report.List.GegenereerdOp.String, report.List.Regelingen)
+	sections := []struct {
+		name    string
+		changes []ListChange
+	}{{"Nieuw", report.Added}, {"Vervallen uit de lijst", report.Removed}, {"Gewijzigd", report.Changed}}
+	for _, section := range sections {
+		fmt.Fprintf(w, "\n%s (%d)\n", section.name, len(section.changes))
+		for _, change := range section.changes {
+			fmt.Fprintf(w, "  %s  %s\n", change.BWBID, change.Titel)
+			for _, field := range change.Fields {
+				fmt.Fprintf(w, "      %s: %q -> %q\n", field.Field, field.Old.String, field.New.String)
+			}
+		}
+	}
+}
+
+func listsHandler(w http.ResponseWriter, r *http.Request) {
+	// Connect to the database
+	db, err := sql.Open("postgres", connectionURL)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+	// Path should be of form "/lists/" or "/lists/[id]/"
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var id int64
+	if len(path) == 2 {
+		if id, err = strconv.ParseInt(path[1], 10, 64); err != nil {
+			http.Error(w, "List not found", http.StatusNotFound)
+			return
+		}
+	} else if len(path) != 1 {
+		http.Error(w, "List not found", http.StatusNotFound)
+		return
+	}
+	report, err := BWBListReport(db, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "List not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println(err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	t, err := template.ParseFiles("lists.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	t.Execute(w, report)
+}
@@ -0,0 +1,291 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	_ "github.com/lib/pq"
+	"io"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Attributes that differ between two downloads of the same version.
+var volatileAttributes = map[string]bool{
+	"gegenereerd":                   true,
+	"xsi:schemaLocation":            true,
+	"xsi:noNamespaceSchemaLocation": true,
+}
+
+// Elements that differ between two downloads of the same version. They are
+// left out together with their content.
+var volatileElements = map[string]bool{
+	"gegenereerd": true,
+}
+
+func rawName(name xml.Name) string {
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+// The version of the canonical form. Snapshots hashed with an older version,
+// or with 0 for a hash of their raw content, are rehashed before a sync.
+const hashVersion = 1
+
+// Write content in a canonical form that only changes when the document
+// does: attributes are sorted, volatile attributes and elements, comments,
+// processing instructions and the doctype are dropped, runs of whitespace
+// become a single space and whitespace-only text that contains a newline,
+// i.e. indentation, is dropped. Only the wetgeving element is kept if there
+// is one, as the toestand around it holds the geldigheid, whose einddatum is
+// set when the next version is published.
+func CanonicalXML(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	d := xml.NewDecoder(bytes.NewReader(content))
+	// The wetgeving element is buf[start:end] once found
+	depth, wetgeving, start, end := 0, 0, 0, -1
+	for {
+		token, err := d.RawToken()
+		if err == io.EOF {
+			if end >= 0 {
+				return buf.Bytes()[start:end], nil
+			}
+			return buf.Bytes(), nil
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if volatileElements[rawName(t.Name)] {
+				if err := skipRaw(d); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			depth++
+			if t.Name.Local == "wetgeving" && wetgeving == 0 && end < 0 {
+				wetgeving, start = depth, buf.Len()
+			}
+			attrs := []string{}
+			for _, a := range t.Attr {
+				if name := rawName(a.Name); !volatileAttributes[name] {
+					var value bytes.Buffer
+					xml.EscapeText(&value, []byte(strings.Join(strings.Fields(a.Value), " ")))
+					attrs = append(attrs, name+`="`+value.String()+`"`)
+				}
+			}
+			sort.Strings(attrs)
+			buf.WriteString("<" + rawName(t.Name))
+			for _, a := range attrs {
+				buf.WriteString(" " + a)
+			}
+			buf.WriteString(">")
+		case xml.EndElement:
+			buf.WriteString("</" + rawName(t.Name) + ">")
+			if depth == wetgeving {
+				wetgeving, end = 0, buf.Len()
+			}
+			depth--
+		case xml.CharData:
+			text := string(t)
+			if strings.TrimSpace(text) == "" && strings.ContainsAny(text, "\r\n") {
+				continue
+			}
+			fields := strings.Fields(text)
+			normalized := strings.Join(fields, " ")
+			if len(text) > 0 && isSpace(text[0]) {
+				normalized = " " + normalized
+			}
+			if len(fields) > 0 && isSpace(text[len(text)-1]) {
+				normalized += " "
+			}
+			xml.EscapeText(&buf, []byte(normalized))
+		}
+	}
+}
+
+// Skip to the end of the element whose start was just read with RawToken.
+func skipRaw(d *xml.Decoder) error {
+	for depth := 1; depth > 0; {
+		token, err := d.RawToken()
+		if err != nil {
+			return err
+		}
+		switch token.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// The hash identifying a version: the sha256 of its canonical form, or of
+// the content itself if it is not well-formed XML.
+func hashContent(content []byte) string {
+	if canonical, err := CanonicalXML(content); err == nil {
+		content = canonical
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(content))
+}
+
+// The tables that refer to a snapshot by its hash.
+var snapshotChildTables = []string{"bwb_snapshot_problems", "bwb_snapshot_bronnen", "bwb_references"}
+
+// The number of snapshots stored with a hash of their raw content, or of an
+// older canonical form.
+func StaleSnapshots(db *sql.DB) (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM bwb_snapshots WHERE hashversion < $1", hashVersion).Scan(&n)
+	return n, err
+}
+
+// Recompute the hash of every snapshot that has a stale hash. Snapshots
+// that turn out to be the same version are merged into one, keeping the
+// earliest pubdate. Returns the number of rehashed and merged snapshots.
+// The sync does this by itself before it starts.
+func RehashSnapshots(connectionURL string) (rehashed, merged int, err error) {
+	// Connect to the database
+	db, err := sql.Open("postgres", connectionURL)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer db.Close()
+
+	// Databases from before the series lack the analysis columns
+	if err = migrateDatabase(db); err != nil {
+		return 0, 0, err
+	}
+	return rehashSnapshots(db)
+}
+
+func rehashSnapshots(db *sql.DB) (rehashed, merged int, err error) {
+	rows, err := db.Query("SELECT hash FROM bwb_snapshots WHERE hashversion < $1 ORDER BY bwbid, pubdate, hash", hashVersion)
+	if err != nil {
+		return 0, 0, err
+	}
+	hashes := []string{}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	for _, hash := range hashes {
+		result, err := rehashSnapshot(db, hash)
+		if err != nil {
+			return rehashed, merged, err
+		}
+		switch result {
+		case snapshotRehashed:
+			rehashed++
+		case snapshotMerged:
+			merged++
+		}
+	}
+	log.Println("Rehashed", rehashed, "snapshots, merged", merged, "duplicates.")
+	return rehashed, merged, nil
+}
+
+const (
+	snapshotUnchanged = iota
+	snapshotRehashed
+	snapshotMerged
+)
+
+func rehashSnapshot(db *sql.DB, hash string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var content string
+	err = tx.QueryRow("SELECT content FROM bwb_snapshots WHERE hash=$1 FOR UPDATE", hash).Scan(&content)
+	if err != nil {
+		return 0, err
+	}
+	newHash := hashContent([]byte(content))
+	if newHash == hash {
+		_, err = tx.Exec("UPDATE bwb_snapshots SET hashversion=$2 WHERE hash=$1", hash, hashVersion)
+		if err != nil {
+			return 0, err
+		}
+		return snapshotUnchanged, tx.Commit()
+	}
+
+	var exists bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM bwb_snapshots WHERE hash=$1)", newHash).Scan(&exists)
+	if err != nil {
+		return 0, err
+	}
+	result := snapshotRehashed
+	if exists {
+		// Another snapshot of the same version: keep that one, with the
+		// earliest pubdate of both, and drop this one with its analysis.
+		result = snapshotMerged
+		_, err = tx.Exec(`UPDATE bwb_snapshots SET hashversion=$3, pubdate = LEAST(pubdate,
+			(SELECT pubdate FROM bwb_snapshots WHERE hash=$2)) WHERE hash=$1`, newHash, hash, hashVersion)
+		if err != nil {
+			return 0, err
+		}
+		for _, table := range snapshotChildTables {
+			if _, err = tx.Exec("DELETE FROM "+table+" WHERE hash=$1", hash); err != nil {
+				return 0, err
+			}
+		}
+	} else {
+		_, err = tx.Exec(`INSERT INTO bwb_snapshots
+			(hash, bwbid, pubdate, content, valid, inwerkingtreding, geldig_van, geldig_tot, hashversion)
+			SELECT $2, bwbid, pubdate, content, valid, inwerkingtreding, geldig_van, geldig_tot, $3
+			FROM bwb_snapshots WHERE hash=$1`, hash, newHash, hashVersion)
+		if err != nil {
+			return 0, err
+		}
+		for _, table := range snapshotChildTables {
+			if _, err = tx.Exec("UPDATE "+table+" SET hash=$2 WHERE hash=$1", hash, newHash); err != nil {
+				return 0, err
+			}
+		}
+	}
+	// The manifest remembers expressions by hash, not by reference
+	if _, err = tx.Exec("UPDATE bwb_manifest_expressions SET hash=$2 WHERE hash=$1", hash, newHash); err != nil {
+		return 0, err
+	}
+	if _, err = tx.Exec("DELETE FROM bwb_snapshots WHERE hash=$1", hash); err != nil {
+		return 0, err
+	}
+	return result, tx.Commit()
+}
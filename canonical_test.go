@@ -0,0 +1,87 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestCanonicalXML(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{
+			`<?xml version="1.0"?><!DOCTYPE toestand><!-- x --><toestand b="2" a="1" gegenereerd="2014-03-12"/>`,
+			`<toestand a="1" b="2"></toestand>`,
+		},
+		{
+			"<wetgeving>\n  <al>Een   lange\n tekst</al>\n  <al> en </al>\n</wetgeving>",
+			`<wetgeving><al>Een lange tekst</al><al> en </al></wetgeving>`,
+		},
+		{
+			`<toestand><gegenereerd>2014-03-12<x/></gegenereerd><al a="&lt;&amp;&quot;">&lt;x&gt; &amp; y</al></toestand>`,
+			`<toestand><al a="&lt;&amp;&#34;">&lt;x&gt; &amp; y</al></toestand>`,
+		},
+		{
+			// Only the wetgeving is kept, without the toestand around it
+			`<toestand inwerkingtreding="2010-03-15"><geldigheid begindatum="2010-03-15"/><wetgeving soort="wet"><al/></wetgeving></toestand>`,
+			`<wetgeving soort="wet"><al></al></wetgeving>`,
+		},
+		{
+			`<toestand xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:noNamespaceSchemaLocation="toestand.xsd"><al/></toestand>`,
+			`<toestand xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"><al></al></toestand>`,
+		},
+	}
+	for _, test := range tests {
+		got, err := CanonicalXML([]byte(test.content))
+		if err != nil {
+			t.Errorf("%s: %v", test.content, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", test.content, got, test.want)
+		}
+	}
+
+	if _, err := CanonicalXML([]byte("<toestand><al a=1></al></toestand>")); err == nil {
+		t.Error("no error for a malformed attribute")
+	}
+}
+
+// Two downloads of the same version that differ in generation time and
+// layout, or in the einddatum it got when the next version was published,
+// have the same hash; a change in the text gives a new one.
+func TestHashContent(t *testing.T) {
+	hash := func(name string) string {
+		content, err := ioutil.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hashContent(content)
+	}
+	if hash("xml.php/BWBR0005537/2010-03-15.xml") != hash("xml.php/BWBR0005537/2012-06-01.xml") {
+		t.Error("the same version has two hashes")
+	}
+	if hash("einddatum/BWBR0005537-open.xml") != hash("einddatum/BWBR0005537-einddatum.xml") {
+		t.Error("the einddatum changes the hash")
+	}
+	if hash("xml.php/BWBR0005537/2010-03-15.xml") == hash("xml.php/BWBR0005537/2015-07-01.xml") {
+		t.Error("different versions have the same hash")
+	}
+}
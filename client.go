@@ -0,0 +1,132 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const DefaultUserAgent = "wetsgeschiedenis (+https://github.com/floort/wetsgeschiedenis)"
+
+// An HTTP client that is shared by all workers. It spreads requests to stay
+// within a requests-per-second budget, gives every request a deadline and
+// retries timeouts and server errors with exponential backoff.
+type PoliteClient struct {
+	Client     *http.Client
+	UserAgent  string
+	MaxRetries int
+	Backoff    time.Duration // Delay before the first retry, doubled every retry
+
+	interval time.Duration // Minimal time between requests
+	mu       sync.Mutex
+	next     time.Time // Earliest time the next request may start
+}
+
+func NewPoliteClient(requestsPerSecond float64, timeout time.Duration, retries int) *PoliteClient {
+	c := &PoliteClient{
+		Client:     &http.Client{Timeout: timeout},
+		UserAgent:  DefaultUserAgent,
+		MaxRetries: retries,
+		Backoff:    time.Second,
+	}
+	if requestsPerSecond > 0 {
+		c.interval = time.Duration(float64(time.Second) / requestsPerSecond)
+	}
+	return c
+}
+
+// Block until the rate limit allows another request, or until the context
+// is cancelled.
+func (c *PoliteClient) wait(ctx context.Context) error {
+	c.mu.Lock()
+	now := time.Now()
+	if c.next.Before(now) {
+		c.next = now
+	}
+	delay := c.next.Sub(now)
+	c.next = c.next.Add(c.interval)
+	c.mu.Unlock()
+	if !sleep(ctx, delay) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// Whether a response with this status is worth retrying. Errors without a
+// status, like timeouts and connection resets, are always retried.
+func retryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests
+}
+
+// The final non-200 response to a request.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return e.URL + ": " + e.Status
+}
+
+// Get url and pass the body of a 200 response to read. Timeouts, connection
+// errors and 5xx responses are retried, also when they happen while read is
+// consuming the body, so read has to discard what an earlier attempt
+// produced. Other responses are returned as a *StatusError. Cancelling the
+// context aborts the request and any waiting before it.
+func (c *PoliteClient) Get(ctx context.Context, url string, read func(io.Reader) error) error {
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		if err := c.wait(ctx); err != nil {
+			return err
+		}
+		err := c.get(ctx, url, read)
+		if err == nil || attempt >= c.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+		if se, ok := err.(*StatusError); ok && !retryableStatus(se.StatusCode) {
+			return err
+		}
+		log.Printf("Retrying %s in %s: %v", url, backoff, err)
+		if !sleep(ctx, backoff) {
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (c *PoliteClient) get(ctx context.Context, url string, read func(io.Reader) error) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", c.UserAgent)
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{url, resp.StatusCode, resp.Status}
+	}
+	return read(resp.Body)
+}
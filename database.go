@@ -21,6 +21,7 @@ import (
 	_ "github.com/lib/pq"
 )
 
+// Drop all tables and create them again.
 func ResetDatabase(connectionURL string) error {
 	// Connect to the database
 	db, err := sql.Open("postgres", connectionURL)
@@ -29,13 +30,52 @@ func ResetDatabase(connectionURL string) error {
 	}
 	defer db.Close()
 
-	// Create all tables
-	_, err = db.Query(`
+	_, err = db.Exec(`
 		-- Drop all Tables
+		DROP TABLE IF EXISTS sync_jobs;
+		DROP TABLE IF EXISTS bwb_manifest_expressions;
+		DROP TABLE IF EXISTS bwb_eu_richtlijnen;
+		DROP TABLE IF EXISTS bwb_verantwoordelijken;
+		DROP TABLE IF EXISTS bwb_niet_officiele_titels;
+		DROP TABLE IF EXISTS bwb_afkortingen;
+		DROP TABLE IF EXISTS bwb_citeertitels;
+		DROP TABLE IF EXISTS bwb_list_changes;
+		DROP TABLE IF EXISTS bwb_documents_history;
+		DROP TABLE IF EXISTS bwb_references;
+		DROP TABLE IF EXISTS bwb_snapshot_bronnen;
+		DROP TABLE IF EXISTS bwb_snapshot_problems;
 		DROP TABLE IF EXISTS bwb_snapshots;
 		DROP TABLE IF EXISTS bwb_documents;
-		-- Recreate all tables
-		CREATE TABLE bwb_documents
+		DROP TABLE IF EXISTS bwb_lists;
+		`)
+	if err != nil {
+		return err
+	}
+	return migrateDatabase(db)
+}
+
+// Create the tables, columns and indexes that are missing, so a database
+// created by an older version can be used without a reset.
+func MigrateDatabase(connectionURL string) error {
+	// Connect to the database
+	db, err := sql.Open("postgres", connectionURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return migrateDatabase(db)
+}
+
+func migrateDatabase(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS bwb_lists
+		(
+			id				serial					PRIMARY KEY,
+			gegenereerdop	timestamp with time zone	NULL UNIQUE,
+			downloaded		timestamp with time zone	NOT NULL,
+			regelingen		integer					NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS bwb_documents
 		(
 			bwbid			character varying(32) 	PRIMARY KEY,
 			officieletitel	character varying(2048)	NOT NULL,
@@ -43,19 +83,133 @@ func ResetDatabase(connectionURL string) error {
 			status			character varying(64)	NOT NULL,
 			regelingsoort	character varying(64)	NOT NULL,
 			startdatum		date					NULL,
-			vervaldatum		date					NULL
+			vervaldatum		date					NULL,
+			laatstewijziging	date				NULL,
+			laatstesync		timestamp				NULL,
+			laatstelijst	integer					NULL REFERENCES bwb_lists(id)
 		);
-		CREATE TABLE bwb_snapshots
+		ALTER TABLE bwb_documents
+			ADD COLUMN IF NOT EXISTS laatstewijziging	date		NULL,
+			ADD COLUMN IF NOT EXISTS laatstesync		timestamp	NULL,
+			ADD COLUMN IF NOT EXISTS laatstelijst		integer		NULL REFERENCES bwb_lists(id);
+		CREATE TABLE IF NOT EXISTS bwb_snapshots
 		(
 			hash	character varying(128)	PRIMARY KEY,
 			bwbid	character varying(32)	REFERENCES bwb_documents(bwbid),
 			pubdate	date					NOT NULL,
-			content	text					NOT NULL
+			content	text					NOT NULL,
+			valid	boolean					NULL,
+			inwerkingtreding	date		NULL,
+			geldig_van			date		NULL,
+			geldig_tot			date		NULL,
+			hashversion			integer		NOT NULL DEFAULT 0
+		);
+		ALTER TABLE bwb_snapshots
+			ADD COLUMN IF NOT EXISTS valid				boolean	NULL,
+			ADD COLUMN IF NOT EXISTS inwerkingtreding	date	NULL,
+			ADD COLUMN IF NOT EXISTS geldig_van			date	NULL,
+			ADD COLUMN IF NOT EXISTS geldig_tot			date	NULL,
+			ADD COLUMN IF NOT EXISTS hashversion		integer	NOT NULL DEFAULT 0;
+		CREATE INDEX IF NOT EXISTS pubdate_idx ON bwb_snapshots(pubdate);
+		CREATE TABLE IF NOT EXISTS bwb_snapshot_problems
+		(
+			hash	character varying(128)	REFERENCES bwb_snapshots(hash),
+			kind	character varying(64)	NOT NULL,
+			path	character varying(1024)	NOT NULL,
+			message	text					NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS problems_hash_idx ON bwb_snapshot_problems(hash);
+		CREATE TABLE IF NOT EXISTS bwb_snapshot_bronnen
+		(
+			hash			character varying(128)	REFERENCES bwb_snapshots(hash),
+			soort			character varying(64)	NOT NULL,
+			effect			character varying(256)	NOT NULL,
+			jaargang		character varying(16)	NOT NULL,
+			nummer			character varying(64)	NOT NULL,
+			uitgiftedatum	date					NULL
+		);
+		CREATE INDEX IF NOT EXISTS bronnen_hash_idx ON bwb_snapshot_bronnen(hash);
+		CREATE TABLE IF NOT EXISTS bwb_references
+		(
+			hash			character varying(128)	REFERENCES bwb_snapshots(hash),
+			source_artikel	character varying(256)	NOT NULL,
+			type			character varying(16)	NOT NULL,
+			target_bwbid	character varying(32)	NOT NULL,
+			target_artikel	character varying(256)	NOT NULL,
+			jci				character varying(1024)	NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS references_hash_idx ON bwb_references(hash);
+		CREATE INDEX IF NOT EXISTS references_target_idx ON bwb_references(target_bwbid, target_artikel);
+		CREATE TABLE IF NOT EXISTS sync_jobs
+		(
+			bwbid			character varying(32)	PRIMARY KEY REFERENCES bwb_documents(bwbid),
+			state			character varying(16)	NOT NULL,
+			attempts		integer					NOT NULL DEFAULT 0,
+			last_error		text					NULL,
+			leased_until	timestamp with time zone	NULL,
+			created			timestamp with time zone	NOT NULL,
+			updated			timestamp with time zone	NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS sync_jobs_state_idx ON sync_jobs(state, updated);
+		CREATE TABLE IF NOT EXISTS bwb_documents_history
+		(
+			bwbid		character varying(32)	REFERENCES bwb_documents(bwbid),
+			field		character varying(32)	NOT NULL,
+			old_value	character varying(2048)	NULL,
+			new_value	character varying(2048)	NULL,
+			changed		timestamp with time zone	NOT NULL,
+			list_id		integer					NULL REFERENCES bwb_lists(id)
+		);
+		ALTER TABLE bwb_documents_history
+			ADD COLUMN IF NOT EXISTS list_id	integer	NULL REFERENCES bwb_lists(id);
+		CREATE INDEX IF NOT EXISTS documents_history_idx ON bwb_documents_history(bwbid, changed);
+		CREATE TABLE IF NOT EXISTS bwb_list_changes
+		(
+			list_id		integer					REFERENCES bwb_lists(id),
+			bwbid		character varying(32)	REFERENCES bwb_documents(bwbid),
+			change		character varying(16)	NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS list_changes_idx ON bwb_list_changes(list_id);
+		CREATE TABLE IF NOT EXISTS bwb_citeertitels
+		(
+			bwbid				character varying(32)	REFERENCES bwb_documents(bwbid),
+			titel				character varying(1024)	NOT NULL,
+			status				character varying(64)	NOT NULL,
+			inwerkingtreding	date					NULL
+		);
+		CREATE INDEX IF NOT EXISTS citeertitels_idx ON bwb_citeertitels(lower(titel));
+		CREATE TABLE IF NOT EXISTS bwb_afkortingen
+		(
+			bwbid		character varying(32)	REFERENCES bwb_documents(bwbid),
+			afkorting	character varying(256)	NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS afkortingen_idx ON bwb_afkortingen(lower(afkorting));
+		CREATE TABLE IF NOT EXISTS bwb_niet_officiele_titels
+		(
+			bwbid	character varying(32)	REFERENCES bwb_documents(bwbid),
+			titel	character varying(1024)	NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS niet_officiele_titels_idx ON bwb_niet_officiele_titels(lower(titel));
+		CREATE TABLE IF NOT EXISTS bwb_verantwoordelijken
+		(
+			bwbid		character varying(32)	REFERENCES bwb_documents(bwbid),
+			organisatie	character varying(256)	NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS verantwoordelijken_idx ON bwb_verantwoordelijken(bwbid);
+		CREATE TABLE IF NOT EXISTS bwb_eu_richtlijnen
+		(
+			bwbid		character varying(32)	REFERENCES bwb_documents(bwbid),
+			richtlijn	character varying(256)	NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS eu_richtlijnen_idx ON bwb_eu_richtlijnen(bwbid);
+		CREATE TABLE IF NOT EXISTS bwb_manifest_expressions
+		(
+			bwbid	character varying(32)	REFERENCES bwb_documents(bwbid),
+			label	character varying(256)	NOT NULL,
+			hash	character varying(128)	NOT NULL,
+			fetched	timestamp with time zone	NOT NULL,
+			PRIMARY KEY (bwbid, label)
 		);
-		CREATE INDEX pubdate_idx ON bwb_snapshots(pubdate);
 		`)
-	if err != nil {
-		return err
-	}
-	return nil
+	return err
 }
@@ -0,0 +1,371 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"html/template"
+	"strings"
+)
+
+type ChangeKind int
+
+const (
+	ArtikelUnchanged ChangeKind = iota
+	ArtikelAdded
+	ArtikelRemoved
+	ArtikelModified
+	ArtikelRenumbered
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ArtikelUnchanged:
+		return "unchanged"
+	case ArtikelAdded:
+		return "added"
+	case ArtikelRemoved:
+		return "removed"
+	case ArtikelModified:
+		return "modified"
+	case ArtikelRenumbered:
+		return "renumbered"
+	}
+	return "unknown"
+}
+
+// A single entry in a ChangeSet. Old is nil for added articles and New is
+// nil for removed articles.
+type ArtikelChange struct {
+	Kind ChangeKind
+	Old  *ArtikelType
+	New  *ArtikelType
+}
+
+// The key of the article in the old version, e.g. "Artikel 3".
+func (c ArtikelChange) OldKey() string {
+	if c.Old == nil {
+		return ""
+	}
+	return artikelKey(*c.Old)
+}
+
+// The key of the article in the new version, e.g. "Artikel 4".
+func (c ArtikelChange) NewKey() string {
+	if c.New == nil {
+		return ""
+	}
+	return artikelKey(*c.New)
+}
+
+// The key of the article in whichever version contains it, preferring the
+// new one.
+func (c ArtikelChange) Key() string {
+	if c.New != nil {
+		return c.NewKey()
+	}
+	return c.OldKey()
+}
+
+// The text of the article with the word level changes marked up.
+func (c ArtikelChange) TekstDiff() template.HTML {
+	var old, new string
+	if c.Old != nil {
+		old = c.Old.Tekst
+	}
+	if c.New != nil {
+		new = c.New.Tekst
+	}
+	return WordDiffHTML(old, new)
+}
+
+// The changes between the leden of the two versions of the article.
+func (c ArtikelChange) Leden() []LidChange {
+	var old, new []LidType
+	if c.Old != nil {
+		old = c.Old.Leden
+	}
+	if c.New != nil {
+		new = c.New.Leden
+	}
+	return DiffLeden(old, new)
+}
+
+type LidChange struct {
+	Kind ChangeKind
+	Old  *LidType
+	New  *LidType
+}
+
+// The text of the lid with the word level changes marked up.
+func (c LidChange) TekstDiff() template.HTML {
+	var old, new string
+	if c.Old != nil {
+		old = c.Old.Tekst()
+	}
+	if c.New != nil {
+		new = c.New.Tekst()
+	}
+	return WordDiffHTML(old, new)
+}
+
+// Compare the leden of two versions of an article, matched on their number.
+func DiffLeden(old, new []LidType) []LidChange {
+	oldByNr := make(map[string]int)
+	for i, l := range old {
+		oldByNr[l.Nr] = i
+	}
+	emitted := make([]bool, len(old))
+	changes := []LidChange{}
+	emitRemovedBefore := func(n int) {
+		for i := 0; i < n; i++ {
+			if !emitted[i] {
+				emitted[i] = true
+				changes = append(changes, LidChange{ArtikelRemoved, &old[i], nil})
+			}
+		}
+	}
+	for j := range new {
+		i, ok := oldByNr[new[j].Nr]
+		if !ok || emitted[i] {
+			changes = append(changes, LidChange{ArtikelAdded, nil, &new[j]})
+			continue
+		}
+		emitRemovedBefore(i)
+		emitted[i] = true
+		kind := ArtikelUnchanged
+		if old[i].Tekst() != new[j].Tekst() {
+			kind = ArtikelModified
+		}
+		changes = append(changes, LidChange{kind, &old[i], &new[j]})
+	}
+	emitRemovedBefore(len(old))
+	return changes
+}
+
+// All articles of two versions in document order, each with the kind of
+// change between them.
+type ChangeSet struct {
+	Artikelen []ArtikelChange
+	Bijlagen  []BijlageChange
+}
+
+// Only the entries that are not ArtikelUnchanged.
+func (cs ChangeSet) Changes() []ArtikelChange {
+	changes := []ArtikelChange{}
+	for _, c := range cs.Artikelen {
+		if c.Kind != ArtikelUnchanged {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// Only the entries of the given kind.
+func (cs ChangeSet) OfKind(kind ChangeKind) []ArtikelChange {
+	changes := []ArtikelChange{}
+	for _, c := range cs.Artikelen {
+		if c.Kind == kind {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+func (cs ChangeSet) HasChanges() bool {
+	for _, c := range cs.Artikelen {
+		if c.Kind != ArtikelUnchanged {
+			return true
+		}
+	}
+	for _, c := range cs.Bijlagen {
+		if c.Kind != ArtikelUnchanged {
+			return true
+		}
+	}
+	return false
+}
+
+type BijlageChange struct {
+	Kind ChangeKind
+	Old  *BijlageType
+	New  *BijlageType
+}
+
+func (c BijlageChange) Key() string {
+	b := c.New
+	if b == nil {
+		b = c.Old
+	}
+	return bijlageKey(*b)
+}
+
+// The changed cells of all tables in the bijlage. Tables are paired by
+// their position in the bijlage.
+func (c BijlageChange) Cells() []CellChange {
+	var old, new []*TableType
+	if c.Old != nil {
+		old = c.Old.Tables()
+	}
+	if c.New != nil {
+		new = c.New.Tables()
+	}
+	changes := []CellChange{}
+	for i := 0; i < len(old) || i < len(new); i++ {
+		var a, b *TableType
+		if i < len(old) {
+			a = old[i]
+		}
+		if i < len(new) {
+			b = new[i]
+		}
+		for _, cell := range DiffTables(a, b) {
+			cell.Table = i
+			changes = append(changes, cell)
+		}
+	}
+	return changes
+}
+
+// The text of the bijlage with the word level changes marked up.
+func (c BijlageChange) TekstDiff() template.HTML {
+	var old, new string
+	if c.Old != nil {
+		old = c.Old.Tekst()
+	}
+	if c.New != nil {
+		new = c.New.Tekst()
+	}
+	return WordDiffHTML(old, new)
+}
+
+func bijlageKey(b BijlageType) string {
+	return strings.TrimSpace(b.Kop.Label + " " + b.Kop.Nr + " " + b.Kop.Titel)
+}
+
+// Compare the bijlagen of two versions, matched on their kop.
+func DiffBijlagen(old, new []BijlageType) []BijlageChange {
+	oldByKey := make(map[string]int)
+	for i, b := range old {
+		oldByKey[bijlageKey(b)] = i
+	}
+	emitted := make([]bool, len(old))
+	changes := []BijlageChange{}
+	emitRemovedBefore := func(n int) {
+		for i := 0; i < n; i++ {
+			if !emitted[i] {
+				emitted[i] = true
+				changes = append(changes, BijlageChange{ArtikelRemoved, &old[i], nil})
+			}
+		}
+	}
+	for j := range new {
+		i, ok := oldByKey[bijlageKey(new[j])]
+		if !ok || emitted[i] {
+			changes = append(changes, BijlageChange{ArtikelAdded, nil, &new[j]})
+			continue
+		}
+		emitRemovedBefore(i)
+		emitted[i] = true
+		kind := ArtikelUnchanged
+		if old[i].Tekst() != new[j].Tekst() {
+			kind = ArtikelModified
+		}
+		changes = append(changes, BijlageChange{kind, &old[i], &new[j]})
+	}
+	emitRemovedBefore(len(old))
+	return changes
+}
+
+func artikelKey(a ArtikelType) string {
+	return strings.TrimSpace(a.Label + " " + a.Nr)
+}
+
+// Compare the articles and bijlagen of two versions of a regeling. Articles
+// are matched on their Label and Nr. An article that disappeared under one
+// key and appeared under another with the same text is reported as
+// renumbered.
+func DiffWetgeving(old, new *WetgevingType) ChangeSet {
+	cs := DiffArtikelen(old.Artikelen, new.Artikelen)
+	cs.Bijlagen = DiffBijlagen(old.Bijlagen, new.Bijlagen)
+	return cs
+}
+
+func DiffArtikelen(old, new []ArtikelType) ChangeSet {
+	// Match articles on their key
+	oldByKey := make(map[string]int)
+	for i, a := range old {
+		oldByKey[artikelKey(a)] = i
+	}
+	newToOld := make([]int, len(new))
+	oldMatched := make([]bool, len(old))
+	for j, b := range new {
+		newToOld[j] = -1
+		if i, ok := oldByKey[artikelKey(b)]; ok && !oldMatched[i] {
+			newToOld[j] = i
+			oldMatched[i] = true
+		}
+	}
+	// Match the remaining articles on their text to find renumberings
+	oldByTekst := make(map[string][]int)
+	for i, a := range old {
+		if !oldMatched[i] && a.Tekst != "" {
+			oldByTekst[a.Tekst] = append(oldByTekst[a.Tekst], i)
+		}
+	}
+	for j, b := range new {
+		if newToOld[j] != -1 {
+			continue
+		}
+		if candidates := oldByTekst[b.Tekst]; len(candidates) > 0 {
+			newToOld[j] = candidates[0]
+			oldMatched[candidates[0]] = true
+			oldByTekst[b.Tekst] = candidates[1:]
+		}
+	}
+
+	// Walk the new version in order, emitting removed articles at the
+	// position they had in the old version.
+	cs := ChangeSet{}
+	emitted := make([]bool, len(old))
+	emitRemovedBefore := func(n int) {
+		for i := 0; i < n; i++ {
+			if !oldMatched[i] && !emitted[i] {
+				emitted[i] = true
+				cs.Artikelen = append(cs.Artikelen, ArtikelChange{ArtikelRemoved, &old[i], nil})
+			}
+		}
+	}
+	for j := range new {
+		i := newToOld[j]
+		if i == -1 {
+			cs.Artikelen = append(cs.Artikelen, ArtikelChange{ArtikelAdded, nil, &new[j]})
+			continue
+		}
+		emitRemovedBefore(i)
+		emitted[i] = true
+		change := ArtikelChange{ArtikelUnchanged, &old[i], &new[j]}
+		switch {
+		case artikelKey(old[i]) != artikelKey(new[j]):
+			change.Kind = ArtikelRenumbered
+		case old[i].Tekst != new[j].Tekst:
+			change.Kind = ArtikelModified
+		}
+		cs.Artikelen = append(cs.Artikelen, change)
+	}
+	emitRemovedBefore(len(old))
+	return cs
+}
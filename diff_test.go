@@ -0,0 +1,94 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"strings"
+	"testing"
+)
+
+func testArtikelen(s string) []ArtikelType {
+	artikelen := []ArtikelType{}
+	for _, a := range strings.Fields(s) {
+		nrTekst := strings.SplitN(a, "=", 2)
+		artikelen = append(artikelen, ArtikelType{Label: "Artikel", Nr: nrTekst[0], Tekst: nrTekst[1]})
+	}
+	return artikelen
+}
+
+// The changes as "kind old>new", with the article numbers.
+func formatArtikelChanges(cs ChangeSet) string {
+	s := []string{}
+	for _, c := range cs.Artikelen {
+		var old, new string
+		if c.Old != nil {
+			old = c.Old.Nr
+		}
+		if c.New != nil {
+			new = c.New.Nr
+		}
+		s = append(s, c.Kind.String()+" "+old+">"+new)
+	}
+	return strings.Join(s, ", ")
+}
+
+func TestDiffArtikelen(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want string
+	}{
+		{"unchanged", "1=a 2=b", "1=a 2=b", "unchanged 1>1, unchanged 2>2"},
+		{"modified", "1=a 2=b", "1=a 2=c", "unchanged 1>1, modified 2>2"},
+		{"removed in place", "1=a 2=b 3=c", "1=a 3=c", "unchanged 1>1, removed 2>, unchanged 3>3"},
+		{"added in place", "1=a 2=b", "1=a 1a=x 2=b", "unchanged 1>1, added >1a, unchanged 2>2"},
+		{"removed at the end", "1=a 2=b", "1=a", "unchanged 1>1, removed 2>"},
+		{"renumbered", "1=a 2=b 3=c", "1=a 3=c 4=b", "unchanged 1>1, unchanged 3>3, renumbered 2>4"},
+		// Articles are matched on their number first, so a shift of every
+		// number shows as a changed text under the numbers that remain
+		{"shifted", "1=a 2=b", "2=a 3=b", "removed 1>, modified 2>2, added >3"},
+		// A number that is kept wins over an equal text under another number
+		{"key before text", "2=x", "2=y 3=x", "modified 2>2, added >3"},
+		// Empty articles are not matched on their text
+		{"empty text", "1= 2=b", "2=b 3=", "removed 1>, unchanged 2>2, added >3"},
+	}
+	for _, test := range tests {
+		cs := DiffArtikelen(testArtikelen(test.old), testArtikelen(test.new))
+		if got := formatArtikelChanges(cs); got != test.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDiffLeden(t *testing.T) {
+	lid := func(nr, tekst string) LidType {
+		return LidType{Nr: nr, Blokken: []BlokType{{Al: tekst}}}
+	}
+	old := []LidType{lid("1", "a"), lid("2", "b"), lid("3", "c")}
+	new := []LidType{lid("1", "a"), lid("3", "d"), lid("4", "e")}
+	want := []ChangeKind{ArtikelUnchanged, ArtikelRemoved, ArtikelModified, ArtikelAdded}
+	changes := DiffLeden(old, new)
+	if len(changes) != len(want) {
+		t.Fatalf("got %d changes, want %d", len(changes), len(want))
+	}
+	for i, c := range changes {
+		if c.Kind != want[i] {
+			t.Errorf("change %d is %s, want %s", i, c.Kind, want[i])
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const BWBBaseURL = "http://wetten.overheid.nl"
+
+// The paths of the resources on wetten.overheid.nl
+const (
+	BWBIdListPath = "/BWBIdService/BWBIdList.xml.zip"
+	SnapshotPath  = "/xml.php"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// A source of BWB resources. Path is relative to the root of the source,
+// e.g. SnapshotPath, and query holds the request parameters. Cancelling the
+// context aborts the fetch.
+type Fetcher interface {
+	Fetch(ctx context.Context, path string, query url.Values) (io.ReadCloser, error)
+}
+
+// Fetches resources over HTTP from BaseURL, e.g. BWBBaseURL or a mirror.
+// Fetchers that point at the same servers should share a Client.
+type HTTPFetcher struct {
+	BaseURL string
+	Client  *PoliteClient
+}
+
+func (f HTTPFetcher) URL(path string, query url.Values) string {
+	u := strings.TrimRight(f.BaseURL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// Fetch a resource into memory, so a failure halfway through the body can be
+// retried.
+func (f HTTPFetcher) Fetch(ctx context.Context, path string, query url.Values) (io.ReadCloser, error) {
+	var buf bytes.Buffer
+	err := f.get(ctx, path, query, func(body io.Reader) error {
+		buf.Reset()
+		_, err := io.Copy(&buf, body)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(&buf), nil
+}
+
+// Fetch a resource into file, for resources too large to hold in memory.
+func (f HTTPFetcher) FetchFile(ctx context.Context, path string, query url.Values, file *os.File) error {
+	return f.get(ctx, path, query, func(body io.Reader) error {
+		if err := file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := io.Copy(file, body)
+		return err
+	})
+}
+
+func (f HTTPFetcher) get(ctx context.Context, path string, query url.Values, read func(io.Reader) error) error {
+	err := f.Client.Get(ctx, f.URL(path, query), read)
+	if se, ok := err.(*StatusError); ok && se.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Fetches resources from a directory. Snapshots are versioned by
+// geldigheidsdatum: a request for SnapshotPath with a regelingID and
+// geldigheidsdatum returns the newest file
+// [Dir]/xml.php/[regelingID]/[YYYY-MM-DD].xml that is not after the
+// geldigheidsdatum. Every other path maps directly to a file in Dir.
+type DirFetcher struct {
+	Dir string
+}
+
+func (f DirFetcher) file(p string) string {
+	return filepath.Join(f.Dir, filepath.FromSlash(path.Clean("/"+p)))
+}
+
+func (f DirFetcher) Fetch(ctx context.Context, p string, query url.Values) (io.ReadCloser, error) {
+	name := f.file(p)
+	if bwbid := query.Get("regelingID"); bwbid != "" {
+		date, err := time.Parse(DateFmt, query.Get("geldigheidsdatum"))
+		if err != nil {
+			return nil, err
+		}
+		if name, err = f.version(filepath.Join(name, filepath.Base(bwbid)), date); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// The newest fixture in dir that is valid on date.
+func (f DirFetcher) version(dir string, date time.Time) (string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+	best := ""
+	for _, fi := range files {
+		d, err := time.Parse("2006-01-02", strings.TrimSuffix(fi.Name(), ".xml"))
+		if err != nil || d.After(date) {
+			continue
+		}
+		best = fi.Name() // ReadDir sorts by name, so by date
+	}
+	if best == "" {
+		return "", ErrNotFound
+	}
+	return filepath.Join(dir, best), nil
+}
+
+// Fetchers that can write a resource to a file themselves, e.g. to retry a
+// download that fails halfway.
+type fileFetcher interface {
+	FetchFile(ctx context.Context, path string, query url.Values, file *os.File) error
+}
+
+// Write a resource to file.
+func fetchFile(ctx context.Context, f Fetcher, path string, query url.Values, file *os.File) error {
+	if ff, ok := f.(fileFetcher); ok {
+		return ff.FetchFile(ctx, path, query, file)
+	}
+	body, err := f.Fetch(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.Copy(file, body)
+	return err
+}
+
+// Read a complete resource.
+func fetchAll(ctx context.Context, f Fetcher, path string, query url.Values) ([]byte, error) {
+	body, err := f.Fetch(ctx, path, query)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
+
+// A stand-in for wetten.overheid.nl that serves the fixtures in dir, laid
+// out as described for DirFetcher. It can be used with httptest.NewServer
+// or served on its own to point a sync at.
+func NewFixtureServer(dir string) http.Handler {
+	fetcher := DirFetcher{dir}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := fetcher.Fetch(r.Context(), r.URL.Path, r.URL.Query())
+		if err == ErrNotFound {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer body.Close()
+		io.Copy(w, body)
+	})
+}
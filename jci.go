@@ -0,0 +1,175 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const JCIDateFmt = "2006-01-02"
+
+// A Juriconnect identifier, e.g.
+// "jci1.3:c:BWBR0005537&artikel=1:3&g=2014-01-01".
+type JCI struct {
+	Versie string // 1.3
+	Soort  string // c for geconsolideerde regelingen
+	BWBID  string
+	Params []JCIParam
+}
+
+type JCIParam struct {
+	Name  string
+	Value string
+}
+
+var ErrInvalidJCI = errors.New("invalid JCI identifier")
+
+func ParseJCI(s string) (JCI, error) {
+	j := JCI{}
+	if !strings.HasPrefix(s, "jci") {
+		return j, ErrInvalidJCI
+	}
+	parts := strings.Split(s[len("jci"):], "&")
+	fields := strings.SplitN(parts[0], ":", 3)
+	if len(fields) != 3 || fields[2] == "" {
+		return j, ErrInvalidJCI
+	}
+	j.Versie, j.Soort, j.BWBID = fields[0], fields[1], fields[2]
+	for _, kv := range parts[1:] {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 {
+			return j, ErrInvalidJCI
+		}
+		j.Params = append(j.Params, JCIParam{pair[0], pair[1]})
+	}
+	return j, nil
+}
+
+func (j JCI) String() string {
+	s := "jci" + j.Versie + ":" + j.Soort + ":" + j.BWBID
+	for _, p := range j.Params {
+		s += "&" + p.Name + "=" + p.Value
+	}
+	return s
+}
+
+// The value of the first parameter with the given name.
+func (j JCI) Get(name string) string {
+	for _, p := range j.Params {
+		if p.Name == name {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// The geldigheidsdatum (g parameter) of the identifier, if any.
+func (j JCI) Geldigheidsdatum() (time.Time, bool) {
+	g, err := time.Parse(JCIDateFmt, j.Get("g"))
+	return g, err == nil
+}
+
+// The id of the HTML element of the node the identifier points to. Article
+// numbers are unique within a regeling, so an article is identified by its
+// number alone; other nodes by the path of structural parameters.
+func (j JCI) Anchor() string {
+	if nr := j.Get("artikel"); nr != "" {
+		return "artikel=" + nr
+	}
+	parts := []string{}
+	for _, p := range j.Params {
+		if structuurElementen[p.Name] || p.Name == "bijlage" {
+			parts = append(parts, p.Name+"="+p.Value)
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// The identifier of a structural node. Articles are identified by their
+// number, other nodes by the numbers of all their structural parents. The
+// g parameter is omitted if geldigheid is the zero time.
+func NodeJCI(bwbid string, node *StructuurNode, parents []*StructuurNode, geldigheid time.Time) JCI {
+	j := JCI{Versie: "1.3", Soort: "c", BWBID: bwbid}
+	if node.Element == "artikel" {
+		j.Params = append(j.Params, JCIParam{"artikel", node.Kop.Nr})
+	} else {
+		for _, n := range append(parents, node) {
+			if !tekstElementen[n.Element] && n.Kop.Nr != "" {
+				j.Params = append(j.Params, JCIParam{n.Element, n.Kop.Nr})
+			}
+		}
+	}
+	if !geldigheid.IsZero() {
+		j.Params = append(j.Params, JCIParam{"g", geldigheid.Format(JCIDateFmt)})
+	}
+	return j
+}
+
+// Set the JCI identifier of every structural node and bijlage in the
+// document. Bijlagen without a number are numbered by position.
+func (w *WetgevingType) AssignJCIs(geldigheid time.Time) {
+	w.Wettekst.Walk(func(node *StructuurNode, parents []*StructuurNode) {
+		node.JCI = NodeJCI(w.BWBID, node, parents, geldigheid)
+	})
+	for i := range w.Bijlagen {
+		b := &w.Bijlagen[i]
+		nr := b.Kop.Nr
+		if nr == "" {
+			nr = strconv.Itoa(i + 1)
+		}
+		b.JCI = NodeJCI(w.BWBID, &StructuurNode{Element: "bijlage", Kop: KopType{Nr: nr}}, nil, geldigheid)
+	}
+}
+
+// Redirect a JCI identifier to the version and anchor it points to. The
+// identifier may be given as /jci/?id=[jci] or appended to the path as is,
+// e.g. /jci/jci1.3:c:BWBR0005537&artikel=1:3&g=2014-01-01.
+func jciHandler(w http.ResponseWriter, r *http.Request) {
+	// The & in an unescaped identifier separate its parameters, not those
+	// of the query, so take everything after id= as the identifier.
+	id := ""
+	if i := strings.Index("&"+r.URL.RawQuery, "&id="); i >= 0 {
+		var err error
+		if id, err = url.QueryUnescape(r.URL.RawQuery[i+len("id="):]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		id = strings.TrimPrefix(r.URL.Path, "/jci/")
+		if r.URL.RawQuery != "" {
+			id += "&" + r.URL.RawQuery
+		}
+	}
+	j, err := ParseJCI(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	target := "/single/" + j.BWBID + "/"
+	if g, ok := j.Geldigheidsdatum(); ok {
+		target += SimpleTimeFmt(g) + "/"
+	}
+	if anchor := j.Anchor(); anchor != "" {
+		target += "#" + anchor
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
@@ -0,0 +1,94 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseJCI(t *testing.T) {
+	tests := []struct {
+		id   string
+		want JCI
+	}{
+		{"jci1.3:c:BWBR0005537", JCI{"1.3", "c", "BWBR0005537", nil}},
+		{"jci1.3:c:BWBR0005537&artikel=1:3&g=2014-01-01", JCI{"1.3", "c", "BWBR0005537", []JCIParam{{"artikel", "1:3"}, {"g", "2014-01-01"}}}},
+		{"jci1.31:c:BWBR0001840&hoofdstuk=1&z=2017-03-01", JCI{"1.31", "c", "BWBR0001840", []JCIParam{{"hoofdstuk", "1"}, {"z", "2017-03-01"}}}},
+	}
+	for _, test := range tests {
+		j, err := ParseJCI(test.id)
+		if err != nil {
+			t.Errorf("%s: %v", test.id, err)
+			continue
+		}
+		if !reflect.DeepEqual(j, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.id, j, test.want)
+		}
+		if j.String() != test.id {
+			t.Errorf("%s: formats as %s", test.id, j)
+		}
+	}
+
+	for _, id := range []string{"", "BWBR0005537", "jci1.3:c", "jci1.3:c:", "jci1.3:c:BWBR0005537&artikel"} {
+		if _, err := ParseJCI(id); err != ErrInvalidJCI {
+			t.Errorf("%q: got %v, want ErrInvalidJCI", id, err)
+		}
+	}
+}
+
+func TestJCIAnchor(t *testing.T) {
+	tests := map[string]string{
+		"jci1.3:c:BWBR0005537&artikel=1:3&g=2014-01-01":         "artikel=1:3",
+		"jci1.3:c:BWBR0005537&hoofdstuk=1&titeldeel=1.1&g=2014": "hoofdstuk=1&titeldeel=1.1",
+		"jci1.3:c:BWBR0005537&bijlage=2":                        "bijlage=2",
+	}
+	for id, want := range tests {
+		j, err := ParseJCI(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := j.Anchor(); got != want {
+			t.Errorf("%s: anchor is %q, want %q", id, got, want)
+		}
+	}
+}
+
+func TestAssignJCIs(t *testing.T) {
+	w, err := ParseBWB(testDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.AssignJCIs(time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC))
+	hoofdstuk := w.Wettekst.Children[0]
+	if got := hoofdstuk.JCI.String(); got != "jci1.3:c:BWBR0005537&hoofdstuk=1&g=2014-01-01" {
+		t.Errorf("hoofdstuk has %s", got)
+	}
+	if got := hoofdstuk.Children[0].JCI.String(); got != "jci1.3:c:BWBR0005537&artikel=1:1&g=2014-01-01" {
+		t.Errorf("artikel 1:1 has %s", got)
+	}
+	if got := w.Bijlagen[0].JCI.Anchor(); got != "bijlage=1" {
+		t.Errorf("bijlage has anchor %q, want bijlage=1", got)
+	}
+
+	// Without a geldigheid the g parameter is left out
+	w.AssignJCIs(time.Time{})
+	if got := w.Bijlagen[0].JCI.String(); got != "jci1.3:c:BWBR0005537&bijlage=1" {
+		t.Errorf("bijlage has %s", got)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const BWBRepositoryURL = "http://repository.officiele-overheidspublicaties.nl/bwb"
+
+// The manifest of a regeling in the BWB repository. It lists every
+// toestand (expression) with its validity dates and files.
+type BWBManifest struct {
+	XMLName     xml.Name             `xml:"manifest"`
+	Expressions []ManifestExpression `xml:"expression"`
+}
+
+type ManifestExpression struct {
+	Label                 string         `xml:"label,attr"`
+	DatumInwerkingtreding string         `xml:"metadata>datum_inwerkingtreding"`
+	Einddatum             string         `xml:"metadata>einddatum"`
+	Manifestations        []ManifestItem `xml:"manifestation"`
+}
+
+type ManifestItem struct {
+	Label string   `xml:"label,attr"`
+	Items []string `xml:"item>label"`
+}
+
+// The name of the XML file of the expression, or "" if it has none.
+func (e ManifestExpression) XMLItem() string {
+	for _, m := range e.Manifestations {
+		if m.Label == "xml" && len(m.Items) > 0 {
+			return m.Items[0]
+		}
+	}
+	return ""
+}
+
+// Downloads every version listed in the manifest of a regeling. Fetcher
+// should point at the BWB repository, e.g. an HTTPFetcher for
+// BWBRepositoryURL.
+type ManifestStrategy struct {
+	Fetcher Fetcher
+}
+
+func manifestPath(bwbid string) string {
+	return fmt.Sprintf("/%s/manifest.xml", bwbid)
+}
+
+func expressionPath(bwbid string, e ManifestExpression) string {
+	return fmt.Sprintf("/%s/%s/xml/%s", bwbid, e.Label, e.XMLItem())
+}
+
+func (m ManifestStrategy) Manifest(ctx context.Context, bwbid string) (*BWBManifest, error) {
+	content, err := fetchAll(ctx, m.Fetcher, manifestPath(bwbid), nil)
+	if err != nil {
+		return nil, err
+	}
+	manifest := new(BWBManifest)
+	err = xml.Unmarshal(content, manifest)
+	return manifest, err
+}
+
+func (m ManifestStrategy) Sync(ctx context.Context, db *sql.DB, bwbid string) error {
+	manifest, err := m.Manifest(ctx, bwbid)
+	if err != nil {
+		return err
+	}
+	expressions := manifest.Expressions
+	sort.Slice(expressions, func(i, j int) bool {
+		return expressions[i].DatumInwerkingtreding < expressions[j].DatumInwerkingtreding
+	})
+	for _, e := range expressions {
+		date, err := time.Parse("2006-01-02", e.DatumInwerkingtreding)
+		if err != nil || e.XMLItem() == "" {
+			continue
+		}
+		// Skip expressions we processed before and versions we already hold,
+		// e.g. from probing
+		var count int
+		err = db.QueryRow(`SELECT (SELECT COUNT(*) FROM bwb_manifest_expressions WHERE bwbid=$1 AND label=$3) +
+			(SELECT COUNT(*) FROM bwb_snapshots WHERE bwbid=$1 AND pubdate=$2)`, bwbid, date, e.Label).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		p := probe{date: date}
+		if p.content, err = fetchAll(ctx, m.Fetcher, expressionPath(bwbid, e), nil); err != nil {
+			return err
+		}
+		p.hash = hashContent(p.content)
+		if err = storeSnapshot(db, bwbid, p); err != nil {
+			return err
+		}
+		// The content may equal a version we already hold, in which case
+		// storeSnapshot stored nothing; remember the expression anyway
+		_, err = db.Exec("INSERT INTO bwb_manifest_expressions (bwbid, label, hash, fetched) VALUES ($1, $2, $3, now())",
+			bwbid, e.Label, p.hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -17,7 +17,9 @@ package main
  */
 
 import (
+	"bytes"
 	"encoding/xml"
+	"strings"
 )
 
 type WetgevingType struct {
@@ -27,8 +29,36 @@ type WetgevingType struct {
 	Soort     string        `xml:"soort,attr"`
 	Lang      string        `xml:"lang,attr"`
 	Intitule  IntituleType  `xml:"intitule"`
+	Vorm      string        `xml:"-"` // wet-besluit, regeling, circulaire or verdrag
 	Aanhef    AanhefType    `xml:"wet-besluit>aanhef"`
-	Artikelen []ArtikelType `xml:"wet-besluit>wettekst>titeldeel>artikel"`
+	Wettekst  StructuurNode `xml:"-"`
+	Artikelen []ArtikelType `xml:"-"` // All articles in document order
+	Toestand  ToestandType  `xml:"-"`
+	Bijlagen  []BijlageType `xml:"-"`
+}
+
+// The toestand wrapper around a regeling: the period in which this version
+// is valid and the publications it originates from.
+type ToestandType struct {
+	BWBID            string
+	Inwerkingtreding string
+	GeldigVan        string
+	GeldigTot        string
+	Bronnen          []BronType
+}
+
+// An official publication (Staatsblad, Staatscourant, Tractatenblad) that
+// caused a version of a regeling.
+type BronType struct {
+	Soort         string `xml:"soort,attr"`
+	Effect        string `xml:"effect,attr"`
+	Jaargang      string `xml:"publicatiejaar"`
+	Nummer        string `xml:"publicatienr"`
+	Uitgiftedatum string `xml:"uitgiftedatum"`
+}
+
+func (b BronType) String() string {
+	return strings.TrimSpace(b.Soort + " " + b.Jaargang + ", " + b.Nummer)
 }
 
 type IntituleType struct {
@@ -42,19 +72,432 @@ type AanhefType struct {
 	Afkondiging []string `xml:"afkondiging>al"`
 }
 
+type KopType struct {
+	Label string
+	Nr    string
+	Titel string
+}
+
+// A node in the structural hierarchy of a regeling. Element is the name of
+// the XML element (wettekst, regeling-tekst, boek, deel, titeldeel,
+// hoofdstuk, afdeling, paragraaf, sub-paragraaf, divisie or artikel).
+// Artikel is only set for artikel nodes, which are the leaves of the tree.
+// Blokken holds text that is not part of an article, as in circulaires.
+type StructuurNode struct {
+	Element  string
+	Status   string
+	Kop      KopType
+	Blokken  []BlokType
+	Children []StructuurNode
+	Artikel  *ArtikelType
+	JCI      JCI
+}
+
 type ArtikelType struct {
-	Status   string `xml:"status,attr"`
-	Label    string `xml:"kop>label"`
-	Nr       string `xml:"kop>nr"`
-	Tekst    string `xml:"al"`
+	Status string
+	Label  string
+	Nr     string
+	Titel  string
+	Leden  []LidType
+	Tekst  string // The text of all leden, for comparisons
+}
+
+// A lid of an article. Articles without lid elements get a single LidType
+// with an empty Nr that holds their alineas and lists.
+type LidType struct {
+	Status  string
+	Nr      string
+	Blokken []BlokType
+}
+
+// A block of text inside a lid or list item: an alinea, a list or a table.
+type BlokType struct {
+	Al    string
+	Refs  []ReferenceType // The references in Al
+	Lijst *LijstType
+	Table *TableType
+}
+
+type LijstType struct {
+	Type  string // expliciet or ongemarkeerd
+	Items []LiType
+}
+
+// A list item. Nr is the numbering marker as it appears in the text, e.g.
+// "a." or "1°".
+type LiType struct {
+	Nr      string
+	Blokken []BlokType
+}
+
+// Elements that group articles and other structural elements.
+var structuurElementen = map[string]bool{
+	"boek":               true,
+	"deel":               true,
+	"titeldeel":          true,
+	"hoofdstuk":          true,
+	"afdeling":           true,
+	"paragraaf":          true,
+	"sub-paragraaf":      true,
+	"divisie":            true,
+	"circulaire.divisie": true,
+}
+
+// Read all character data up to the end of the current element, including
+// the text of inline elements like nadruk and extref.
+func readText(d *xml.Decoder) (string, error) {
+	text, err := readRawText(d)
+	return strings.Join(strings.Fields(text), " "), err
+}
+
+// Like readText, but with the whitespace left as it is.
+func readRawText(d *xml.Decoder) (string, error) {
+	var buf bytes.Buffer
+	depth := 1
+	for depth > 0 {
+		t, err := d.Token()
+		if err != nil {
+			return buf.String(), err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			buf.Write(t)
+		}
+	}
+	return buf.String(), nil
+}
+
+func (k *KopType) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			text, err := readText(d)
+			if err != nil {
+				return err
+			}
+			switch t.Name.Local {
+			case "label":
+				k.Label = text
+			case "nr":
+				k.Nr = text
+			case "titel":
+				k.Titel = text
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// Decode a meta-data element into the toestand. Brondata may be nested at
+// different depths, so every publicatie and geldigheid element is used.
+func (ts *ToestandType) decodeMetaData(d *xml.Decoder) error {
+	for depth := 1; depth > 0; {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "publicatie":
+				bron := BronType{}
+				if err := d.DecodeElement(&bron, &t); err != nil {
+					return err
+				}
+				ts.Bronnen = append(ts.Bronnen, bron)
+			case "geldigheid":
+				ts.decodeGeldigheid(t)
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			case "inwerkingtreding":
+				if ts.Inwerkingtreding, err = readText(d); err != nil {
+					return err
+				}
+			default:
+				depth++
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return nil
+}
+
+func (ts *ToestandType) decodeGeldigheid(start xml.StartElement) {
+	if v := attrValue(start, "begindatum"); v != "" {
+		ts.GeldigVan = v
+	}
+	if v := attrValue(start, "einddatum"); v != "" {
+		ts.GeldigTot = v
+	}
+}
+
+func attrValue(start xml.StartElement, name string) string {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func (a *ArtikelType) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	a.Status = attrValue(start, "status")
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "kop":
+				kop := KopType{}
+				if err := d.DecodeElement(&kop, &t); err != nil {
+					return err
+				}
+				a.Label, a.Nr, a.Titel = kop.Label, kop.Nr, kop.Titel
+			case "lid":
+				lid := LidType{}
+				if err := d.DecodeElement(&lid, &t); err != nil {
+					return err
+				}
+				a.Leden = append(a.Leden, lid)
+			case "al", "lijst", "table":
+				// Text directly in the article goes into an unnumbered lid
+				if len(a.Leden) == 0 || a.Leden[len(a.Leden)-1].Nr != "" {
+					a.Leden = append(a.Leden, LidType{})
+				}
+				lid := &a.Leden[len(a.Leden)-1]
+				blok, err := decodeBlok(d, t)
+				if err != nil {
+					return err
+				}
+				lid.Blokken = append(lid.Blokken, blok)
+			default:
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			}
+		case xml.EndElement:
+			a.Tekst = a.tekst()
+			return nil
+		}
+	}
+}
+
+func (l *LidType) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	l.Status = attrValue(start, "status")
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "lidnr":
+				if l.Nr, err = readText(d); err != nil {
+					return err
+				}
+			case "al", "lijst", "table":
+				blok, err := decodeBlok(d, t)
+				if err != nil {
+					return err
+				}
+				l.Blokken = append(l.Blokken, blok)
+			default:
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+func (l *LijstType) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	l.Type = attrValue(start, "type")
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "li" {
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			li := LiType{}
+			if err := d.DecodeElement(&li, &t); err != nil {
+				return err
+			}
+			l.Items = append(l.Items, li)
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+func (li *LiType) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "li.nr":
+				if li.Nr, err = readText(d); err != nil {
+					return err
+				}
+			case "al", "lijst", "table":
+				blok, err := decodeBlok(d, t)
+				if err != nil {
+					return err
+				}
+				li.Blokken = append(li.Blokken, blok)
+			default:
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// Decode an al, lijst or table element into a BlokType.
+func decodeBlok(d *xml.Decoder, start xml.StartElement) (BlokType, error) {
+	switch start.Name.Local {
+	case "lijst":
+		lijst := new(LijstType)
+		err := d.DecodeElement(lijst, &start)
+		return BlokType{Lijst: lijst}, err
+	case "table":
+		table := new(TableType)
+		err := d.DecodeElement(table, &start)
+		return BlokType{Table: table}, err
+	}
+	al, refs, err := readAl(d)
+	return BlokType{Al: al, Refs: refs}, err
+}
+
+func (b BlokType) Tekst() string {
+	if b.Table != nil {
+		return b.Table.Tekst()
+	}
+	if b.Lijst == nil {
+		return b.Al
+	}
+	parts := []string{}
+	for _, li := range b.Lijst.Items {
+		parts = append(parts, li.Tekst())
+	}
+	return strings.Join(parts, "\n")
+}
+
+func (li LiType) Tekst() string {
+	return strings.TrimSpace(li.Nr + " " + blokkenTekst(li.Blokken))
+}
+
+func (l LidType) Tekst() string {
+	return strings.TrimSpace(l.Nr + " " + blokkenTekst(l.Blokken))
+}
+
+func blokkenTekst(blokken []BlokType) string {
+	parts := []string{}
+	for _, b := range blokken {
+		parts = append(parts, b.Tekst())
+	}
+	return strings.Join(parts, "\n")
+}
+
+func (a *ArtikelType) tekst() string {
+	parts := []string{}
+	for _, l := range a.Leden {
+		parts = append(parts, l.Tekst())
+	}
+	return strings.Join(parts, "\n")
+}
+
+// Find a lid by its number, e.g. "2" for the tweede lid. Use "" for
+// articles without numbered leden.
+func (a *ArtikelType) Lid(nr string) *LidType {
+	for i := range a.Leden {
+		if strings.TrimRight(a.Leden[i].Nr, ".") == nr {
+			return &a.Leden[i]
+		}
+	}
+	return nil
+}
+
+// Find a list item in the lid by its marker, e.g. "b" for "onder b".
+func (l *LidType) Onder(nr string) *LiType {
+	return findLi(l.Blokken, nr)
+}
+
+// Find a list item nested in this list item by its marker.
+func (li *LiType) Onder(nr string) *LiType {
+	return findLi(li.Blokken, nr)
+}
+
+func findLi(blokken []BlokType, nr string) *LiType {
+	for _, b := range blokken {
+		if b.Lijst == nil {
+			continue
+		}
+		for i := range b.Lijst.Items {
+			if strings.TrimRight(b.Lijst.Items[i].Nr, ".°)") == nr {
+				return &b.Lijst.Items[i]
+			}
+		}
+	}
+	return nil
+}
+
+// All articles below this node in document order.
+func (n *StructuurNode) Artikelen() []ArtikelType {
+	artikelen := []ArtikelType{}
+	n.Walk(func(node *StructuurNode, parents []*StructuurNode) {
+		if node.Artikel != nil {
+			artikelen = append(artikelen, *node.Artikel)
+		}
+	})
+	return artikelen
+}
+
+// Call fn for this node and every node below it in document order. The
+// parents of a node are passed from the root down and are only valid during
+// the call.
+func (n *StructuurNode) Walk(fn func(node *StructuurNode, parents []*StructuurNode)) {
+	n.walk(fn, nil)
 }
 
-func (w *WetgevingType) Validate() bool {
-	return true
+func (n *StructuurNode) walk(fn func(node *StructuurNode, parents []*StructuurNode), parents []*StructuurNode) {
+	fn(n, parents)
+	parents = append(parents, n)
+	for i := range n.Children {
+		n.Children[i].walk(fn, parents)
+	}
 }
 
 func ParseBWB(document string) (WetgevingType, error) {
-	wetgeving := WetgevingType{}
-	err := xml.Unmarshal([]byte(document), &wetgeving)
-	return wetgeving, err
+	return ParseBWBReader(strings.NewReader(document))
 }
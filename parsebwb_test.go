@@ -0,0 +1,218 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"strings"
+	"testing"
+)
+
+const testDocument = `<toestand bwb-id="BWBR0005537" inwerkingtreding="2014-01-01"><geldigheid begindatum="2014-01-01" einddatum="2014-06-30"/>
+<wetgeving soort="wet"><intitule>Algemene wet bestuursrecht</intitule>
+<meta-data><brondata><oorspronkelijk><publicatie soort="Stb" effect="wijziging"><publicatiejaar>2013</publicatiejaar><publicatienr>514</publicatienr><uitgiftedatum>2013-12-20</uitgiftedatum></publicatie></oorspronkelijk></brondata></meta-data>
+<wet-besluit><aanhef><wij>Wij Beatrix</wij></aanhef><wettekst>
+<hoofdstuk><kop><label>Hoofdstuk</label><nr>1</nr><titel>Inleidende bepalingen</titel></kop>
+<artikel><kop><label>Artikel</label><nr>1:1</nr></kop><lid><lidnr>1</lidnr><al>Onder bestuursorgaan wordt verstaan:</al>
+<lijst type="expliciet"><li><li.nr>a.</li.nr><al>een orgaan</al></li></lijst></lid>
+<lid><lidnr>2</lidnr><al>Tweede lid</al></lid></artikel>
+<artikel><kop><label>Artikel</label><nr>1:2</nr></kop><al>Zie <extref doc="jci1.3:c:BWBR0001840&amp;artikel=1">artikel 1 Grondwet</extref> en <intref doc="jci1.3:c:BWBR0005537&amp;artikel=1:1">1:1</intref>.</al></artikel>
+</hoofdstuk>
+<artikel><kop><label>Artikel</label><nr>99</nr></kop><al>los</al></artikel>
+</wettekst></wet-besluit>
+<bijlage><kop><label>Bijlage</label><nr>1</nr></kop><al>Zie <intref doc="jci1.3:c:BWBR0005537&amp;artikel=99">artikel 99</intref>.</al></bijlage>
+</wetgeving></toestand>`
+
+func TestParseBWB(t *testing.T) {
+	w, err := ParseBWB(testDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.BWBID != "BWBR0005537" || w.Soort != "wet" || w.Vorm != "wet-besluit" {
+		t.Errorf("got %q %q %q, want the BWB id of the toestand, wet and wet-besluit", w.BWBID, w.Soort, w.Vorm)
+	}
+	if w.Intitule.Data != "Algemene wet bestuursrecht" {
+		t.Errorf("intitule is %q", w.Intitule.Data)
+	}
+	if w.Aanhef.Wij != "Wij Beatrix" {
+		t.Errorf("aanhef is %q", w.Aanhef.Wij)
+	}
+
+	// The tree: a hoofdstuk with two articles, followed by a loose article
+	if len(w.Wettekst.Children) != 2 {
+		t.Fatalf("wettekst has %d children, want 2", len(w.Wettekst.Children))
+	}
+	hoofdstuk := w.Wettekst.Children[0]
+	if hoofdstuk.Element != "hoofdstuk" || hoofdstuk.Kop.Nr != "1" || len(hoofdstuk.Children) != 2 {
+		t.Errorf("got %s %s with %d children, want hoofdstuk 1 with 2", hoofdstuk.Element, hoofdstuk.Kop.Nr, len(hoofdstuk.Children))
+	}
+	if got := artikelNrs(w.Artikelen); got != "1:1 1:2 99" {
+		t.Errorf("articles are %q, want 1:1 1:2 99", got)
+	}
+}
+
+func artikelNrs(artikelen []ArtikelType) string {
+	nrs := []string{}
+	for _, a := range artikelen {
+		nrs = append(nrs, a.Nr)
+	}
+	return strings.Join(nrs, " ")
+}
+
+// Describe the structural nodes of the tree, e.g. "hoofdstuk 1 (artikel 1)".
+func describeTree(n StructuurNode) string {
+	s := strings.TrimSpace(n.Element + " " + n.Kop.Nr)
+	if len(n.Children) > 0 {
+		children := []string{}
+		for _, c := range n.Children {
+			children = append(children, describeTree(c))
+		}
+		s += " (" + strings.Join(children, ", ") + ")"
+	}
+	return s
+}
+
+func TestParseUnknownElements(t *testing.T) {
+	w, err := ParseBWB(`<wetgeving bwb-id="BWBR1" soort="wet"><intitule>T</intitule><wet-besluit><wettekst>
+		<hoofdstuk><kop><label>Hoofdstuk</label><nr>1</nr></kop>
+		<meta-data><jcis><jci reference="jci1.3:c:BWBR1&amp;hoofdstuk=1"/></jcis><brondata><oorspronkelijk/></brondata></meta-data>
+		<artikel><kop><label>Artikel</label><nr>1</nr></kop><al>a</al></artikel>
+		<plaatje><illustratie naam="x"/></plaatje>
+		<onbekend><artikel><kop><label>Artikel</label><nr>2</nr></kop><al>b</al></artikel></onbekend>
+		<onbekend><paragraaf><kop><nr>1</nr></kop></paragraaf><al>c</al></onbekend>
+		</hoofdstuk>
+		</wettekst></wet-besluit></wetgeving>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Meta-data and wrappers without articles leave no nodes; the text in
+	// them goes to the enclosing node
+	want := "wettekst (hoofdstuk 1 (artikel 1, onbekend (artikel 2)))"
+	if got := describeTree(w.Wettekst); got != want {
+		t.Errorf("got tree %s, want %s", got, want)
+	}
+	if got := artikelNrs(w.Artikelen); got != "1 2" {
+		t.Errorf("articles are %q, want 1 2", got)
+	}
+	if blokken := w.Wettekst.Children[0].Blokken; len(blokken) != 1 || blokken[0].Al != "c" {
+		t.Errorf("blokken of the hoofdstuk are %+v, want c", blokken)
+	}
+	problems := w.Validate()
+	if len(problems) != 1 || problems[0].Path != "/wetgeving/wet-besluit/wettekst/hoofdstuk[1]/onbekend[1]" {
+		t.Errorf("got problems %v, want only the wrapper of artikel 2", problems)
+	}
+}
+
+func TestParseLeden(t *testing.T) {
+	w, err := ParseBWB(testDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leden := w.Artikelen[0].Leden
+	if len(leden) != 2 || leden[0].Nr != "1" || leden[1].Nr != "2" {
+		t.Fatalf("got %d leden in artikel 1:1, want 1 and 2", len(leden))
+	}
+	if al := leden[0].Blokken[0].Al; al != "Onder bestuursorgaan wordt verstaan:" {
+		t.Errorf("first alinea is %q", al)
+	}
+	if lijst := leden[0].Blokken[1].Lijst; lijst == nil || lijst.Type != "expliciet" || len(lijst.Items) != 1 || lijst.Items[0].Nr != "a." {
+		t.Errorf("the list in artikel 1:1 was not parsed: %+v", leden[0].Blokken)
+	}
+
+	// An article without leden has one lid without a number
+	leden = w.Artikelen[2].Leden
+	if len(leden) != 1 || leden[0].Nr != "" || leden[0].Blokken[0].Al != "los" {
+		t.Errorf("leden of artikel 99 are %+v", leden)
+	}
+
+	// The text of an article includes its nested lists
+	w, err = ParseBWB(`<wetgeving><wet-besluit><wettekst><artikel><kop><nr>1</nr></kop><lid><lidnr>1</lidnr><al>Kop:</al>
+		<lijst><li><li.nr>a.</li.nr><al>een</al><lijst><li><li.nr>1°.</li.nr><al>diep</al></li></lijst></li></lijst></lid></artikel>
+		</wettekst></wet-besluit></wetgeving>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested := w.Artikelen[0].Leden[0].Blokken[1].Lijst.Items[0].Blokken
+	if len(nested) != 2 || nested[1].Lijst == nil || nested[1].Lijst.Items[0].Nr != "1°." {
+		t.Errorf("nested list was not parsed: %+v", nested)
+	}
+	for _, word := range []string{"Kop:", "a.", "een", "1°.", "diep"} {
+		if !strings.Contains(w.Artikelen[0].Tekst, word) {
+			t.Errorf("article text %q lacks %q", w.Artikelen[0].Tekst, word)
+		}
+	}
+}
+
+func TestParseToestand(t *testing.T) {
+	w, err := ParseBWB(testDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toestand := w.Toestand
+	if toestand.BWBID != "BWBR0005537" || toestand.Inwerkingtreding != "2014-01-01" {
+		t.Errorf("toestand is %+v", toestand)
+	}
+	if toestand.GeldigVan != "2014-01-01" || toestand.GeldigTot != "2014-06-30" {
+		t.Errorf("geldig van %q tot %q, want 2014-01-01 tot 2014-06-30", toestand.GeldigVan, toestand.GeldigTot)
+	}
+	if len(toestand.Bronnen) != 1 || toestand.Bronnen[0].String() != "Stb 2013, 514" {
+		t.Fatalf("bronnen are %v, want [Stb 2013, 514]", toestand.Bronnen)
+	}
+	if b := toestand.Bronnen[0]; b.Effect != "wijziging" || b.Uitgiftedatum != "2013-12-20" {
+		t.Errorf("bron is %+v", b)
+	}
+
+	// Geldigheid inside the meta-data does not hide the bronnen after it
+	w, err = ParseBWB(`<toestand bwb-id="BWBR1"><wetgeving><meta-data>
+		<geldigheid begindatum="2015-01-01"/>
+		<brondata><oorspronkelijk><publicatie soort="Stcrt"><publicatiejaar>2014</publicatiejaar><publicatienr>7</publicatienr></publicatie></oorspronkelijk></brondata>
+		</meta-data></wetgeving></toestand>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Toestand.GeldigVan != "2015-01-01" || len(w.Toestand.Bronnen) != 1 {
+		t.Errorf("toestand is %+v", w.Toestand)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	w, err := ParseBWB(testDocument)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"1:2 extref BWBR0001840 1",
+		"1:2 intref BWBR0005537 1:1",
+		"Bijlage 1 intref BWBR0005537 99",
+	}
+	refs := w.References()
+	if len(refs) != len(want) {
+		t.Fatalf("got %d references, want %d: %+v", len(refs), len(want), refs)
+	}
+	for i, ref := range refs {
+		got := strings.Join([]string{ref.SourceArtikel, ref.Type, ref.TargetBWBID, ref.TargetArtikel}, " ")
+		if got != want[i] {
+			t.Errorf("reference %d is %q, want %q", i, got, want[i])
+		}
+	}
+	if refs[0].Tekst != "artikel 1 Grondwet" {
+		t.Errorf("text of the first reference is %q", refs[0].Tekst)
+	}
+	// The text of a reference stays part of the alinea
+	if al := w.Artikelen[1].Leden[0].Blokken[0].Al; al != "Zie artikel 1 Grondwet en 1:1." {
+		t.Errorf("alinea is %q", al)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/xml"
+	_ "github.com/lib/pq"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A reference from the text of a regeling to an article of the same
+// (intref) or another (extref) regeling.
+type ReferenceType struct {
+	Type          string // intref or extref
+	JCI           string // The doc attribute
+	TargetBWBID   string
+	TargetArtikel string
+	Tekst         string
+}
+
+// A reference together with the article it was found in.
+type SourcedReference struct {
+	SourceArtikel string
+	ReferenceType
+}
+
+// Decode an intref or extref. Also returns the character data of the
+// reference with its whitespace intact.
+func decodeReference(d *xml.Decoder, start xml.StartElement) (ReferenceType, string, error) {
+	ref := ReferenceType{Type: start.Name.Local, JCI: attrValue(start, "doc")}
+	ref.TargetBWBID = attrValue(start, "bwb-id")
+	if j, err := ParseJCI(ref.JCI); err == nil {
+		if ref.TargetBWBID == "" && strings.HasPrefix(j.BWBID, "BWB") {
+			ref.TargetBWBID = j.BWBID
+		}
+		ref.TargetArtikel = j.Get("artikel")
+	}
+	raw, err := readRawText(d)
+	ref.Tekst = strings.Join(strings.Fields(raw), " ")
+	return ref, raw, err
+}
+
+// Read the text of an alinea like readText and collect the intref and
+// extref elements in it.
+func readAl(d *xml.Decoder) (string, []ReferenceType, error) {
+	var buf bytes.Buffer
+	refs := []ReferenceType{}
+	depth := 1
+	for depth > 0 {
+		t, err := d.Token()
+		if err != nil {
+			return buf.String(), refs, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "intref" || t.Name.Local == "extref" {
+				ref, raw, err := decodeReference(d, t)
+				if err != nil {
+					return buf.String(), refs, err
+				}
+				refs = append(refs, ref)
+				buf.WriteString(raw)
+				continue
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			buf.Write(t)
+		}
+	}
+	return strings.Join(strings.Fields(buf.String()), " "), refs, nil
+}
+
+func refsIn(blokken []BlokType) []ReferenceType {
+	refs := []ReferenceType{}
+	for _, b := range blokken {
+		refs = append(refs, b.Refs...)
+		if b.Lijst != nil {
+			for _, li := range b.Lijst.Items {
+				refs = append(refs, refsIn(li.Blokken)...)
+			}
+		}
+	}
+	return refs
+}
+
+// All references in the article.
+func (a *ArtikelType) References() []ReferenceType {
+	refs := []ReferenceType{}
+	for _, l := range a.Leden {
+		refs = append(refs, refsIn(l.Blokken)...)
+	}
+	return refs
+}
+
+// All references in the document with the article or bijlage they occur
+// in. Intrefs without a bwb-id point into the document itself.
+func (w *WetgevingType) References() []SourcedReference {
+	refs := []SourcedReference{}
+	add := func(source string, list []ReferenceType) {
+		for _, ref := range list {
+			if ref.TargetBWBID == "" {
+				ref.TargetBWBID = w.BWBID
+			}
+			refs = append(refs, SourcedReference{source, ref})
+		}
+	}
+	for i := range w.Artikelen {
+		add(w.Artikelen[i].Nr, w.Artikelen[i].References())
+	}
+	for i := range w.Bijlagen {
+		add(bijlageKey(w.Bijlagen[i]), refsIn(w.Bijlagen[i].Blokken))
+	}
+	return refs
+}
+
+// Store the references found in the article or bijlage source of the
+// snapshot hash. Intrefs without a bwb-id point into bwbid itself.
+func storeReferences(tx *sql.Tx, hash, bwbid, source string, refs []ReferenceType) error {
+	for _, ref := range refs {
+		if ref.TargetBWBID == "" {
+			ref.TargetBWBID = bwbid
+		}
+		_, err := tx.Exec(`INSERT INTO bwb_references
+			(hash, source_artikel, type, target_bwbid, target_artikel, jci)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			hash, source, ref.Type, ref.TargetBWBID, ref.TargetArtikel, ref.JCI)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A regeling that refers to a target at some point in time.
+type IncomingReference struct {
+	BWBID         string
+	Titel         string
+	PubDate       time.Time
+	SourceArtikel string
+	TargetArtikel string
+}
+
+// Find the references to bwbid (and artikel, if it is not empty) in the
+// versions of all regelingen that were valid on date.
+func ReferencesTo(db *sql.DB, bwbid, artikel string, date time.Time) ([]IncomingReference, error) {
+	rows, err := db.Query(`SELECT s.bwbid, d.titel, s.pubdate, r.source_artikel, r.target_artikel
+		FROM bwb_references r
+		JOIN bwb_snapshots s ON s.hash = r.hash
+		JOIN bwb_documents d ON d.bwbid = s.bwbid
+		WHERE r.target_bwbid = $1
+		AND ($2 = '' OR r.target_artikel = $2)
+		AND s.pubdate = (SELECT MAX(pubdate) FROM bwb_snapshots
+			WHERE bwbid = s.bwbid AND pubdate <= $3)
+		ORDER BY d.titel, r.source_artikel`, bwbid, artikel, date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	refs := []IncomingReference{}
+	for rows.Next() {
+		ref := IncomingReference{}
+		if err := rows.Scan(&ref.BWBID, &ref.Titel, &ref.PubDate, &ref.SourceArtikel, &ref.TargetArtikel); err != nil {
+			return refs, err
+		}
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+type ReferencesPage struct {
+	BWBID      string
+	Titel      string
+	Artikel    string
+	Date       string
+	References []IncomingReference
+}
+
+func referencesHandler(w http.ResponseWriter, r *http.Request) {
+	// Connect to the database
+	db, err := sql.Open("postgres", connectionURL)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+	// Path should be of form "/references/[bwbid]/", optionally with
+	// ?artikel=[nr]&date=[date]
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(path) != 2 {
+		http.Error(w, "Document not found", http.StatusNotFound)
+		return
+	}
+	page := ReferencesPage{BWBID: path[1], Artikel: r.FormValue("artikel")}
+	date := time.Now()
+	if d := r.FormValue("date"); d != "" {
+		if date, err = time.Parse(DateFmt, d); err != nil {
+			http.Error(w, "Invalid date", http.StatusBadRequest)
+			return
+		}
+	}
+	page.Date = SimpleTimeFmt(date)
+	err = db.QueryRow("SELECT titel FROM bwb_documents WHERE bwbid=$1", page.BWBID).Scan(&page.Titel)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	page.References, err = ReferencesTo(db, page.BWBID, page.Artikel, date)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	t, err := template.ParseFiles("references.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	t.Execute(w, page)
+}
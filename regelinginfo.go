@@ -0,0 +1,186 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"database/sql"
+	_ "github.com/lib/pq"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type Citeertitel struct {
+	Titel                  string `xml:"titel"`
+	Status                 string `xml:"status"`
+	InwerkingtredingsDatum string `xml:"InwerkingtredingsDatum"`
+}
+
+// The ministry responsible for a regeling. Older lists put the name directly
+// in the element, newer ones in an Organisatie child.
+type Verantwoordelijke struct {
+	Naam        string `xml:",chardata"`
+	Organisatie string `xml:"Organisatie"`
+}
+
+func (v Verantwoordelijke) String() string {
+	if o := strings.TrimSpace(v.Organisatie); o != "" {
+		return o
+	}
+	return strings.TrimSpace(v.Naam)
+}
+
+// The first citeertitel, which is the one stored in bwb_documents.
+func (info RegelingInfo) Citeertitel() Citeertitel {
+	if len(info.Citeertitels) == 0 {
+		return Citeertitel{}
+	}
+	return info.Citeertitels[0]
+}
+
+// The tables holding the names and other metadata of regelingen. They are
+// filled from staging tables of the same name with a staging_ prefix.
+var regelingNaamTables = []string{"bwb_citeertitels", "bwb_afkortingen", "bwb_niet_officiele_titels", "bwb_verantwoordelijken", "bwb_eu_richtlijnen"}
+
+// Add the names and other metadata of a regeling to the staging tables.
+func (s *bwbIdListStaging) addNamen(info *RegelingInfo) error {
+	for _, c := range info.Citeertitels {
+		if err := s.citeertitels.add(info.BWBId, c.Titel, c.Status, nullDate(c.InwerkingtredingsDatum)); err != nil {
+			return err
+		}
+	}
+	for _, a := range info.Afkortingen {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		if err := s.afkortingen.add(info.BWBId, a); err != nil {
+			return err
+		}
+	}
+	for _, t := range info.NietOfficieleTitels {
+		if t = strings.TrimSpace(t); t == "" {
+			continue
+		}
+		if err := s.nietOfficieleTitels.add(info.BWBId, t); err != nil {
+			return err
+		}
+	}
+	for _, v := range info.Verantwoordelijken {
+		if v.String() == "" {
+			continue
+		}
+		if err := s.verantwoordelijken.add(info.BWBId, v.String()); err != nil {
+			return err
+		}
+	}
+	for _, r := range info.EURichtlijnen {
+		if r = strings.TrimSpace(r); r == "" {
+			continue
+		}
+		if err := s.euRichtlijnen.add(info.BWBId, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replace the names and other metadata of all regelingen with those in the
+// staging tables.
+func storeRegelingNamen(tx *sql.Tx) error {
+	for _, table := range regelingNaamTables {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO " + table + " SELECT * FROM staging_" + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A regeling found by one of its names.
+type RegelingMatch struct {
+	BWBID string
+	Titel string
+	Naam  string // The name that matched
+	Soort string // citeertitel, afkorting or niet-officiële titel
+}
+
+// Find the regelingen with name as citeertitel, afkorting or niet-officiële
+// titel, ignoring case.
+func LookupRegeling(db *sql.DB, name string) ([]RegelingMatch, error) {
+	rows, err := db.Query(`SELECT n.bwbid, d.titel, n.naam, n.soort FROM (
+			SELECT bwbid, afkorting AS naam, 'afkorting' AS soort FROM bwb_afkortingen
+			UNION SELECT bwbid, titel, 'citeertitel' FROM bwb_citeertitels
+			UNION SELECT bwbid, titel, 'niet-officiële titel' FROM bwb_niet_officiele_titels
+		) n
+		JOIN bwb_documents d ON d.bwbid = n.bwbid
+		WHERE lower(n.naam) = lower($1)
+		ORDER BY d.titel, n.bwbid`, strings.TrimSpace(name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	matches := []RegelingMatch{}
+	for rows.Next() {
+		m := RegelingMatch{}
+		if err := rows.Scan(&m.BWBID, &m.Titel, &m.Naam, &m.Soort); err != nil {
+			return matches, err
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+type LookupPage struct {
+	Query   string
+	Matches []RegelingMatch
+}
+
+// Look a regeling up by name with /lookup/?q=[name]. A single match
+// redirects to the regeling.
+func lookupHandler(w http.ResponseWriter, r *http.Request) {
+	// Connect to the database
+	db, err := sql.Open("postgres", connectionURL)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+	page := LookupPage{Query: r.FormValue("q")}
+	if page.Query != "" {
+		page.Matches, err = LookupRegeling(db, page.Query)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(page.Matches) == 1 {
+		http.Redirect(w, r, "/single/"+page.Matches[0].BWBID+"/", http.StatusFound)
+		return
+	}
+	t, err := template.ParseFiles("lookup.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	t.Execute(w, page)
+}
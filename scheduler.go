@@ -0,0 +1,133 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Hours of the day, in local time, during which no sync run is started. A
+// range may wrap around midnight, e.g. 22-6.
+type QuietHours struct {
+	Start, End int
+}
+
+// Parse a range like "8-18". The empty string means no quiet hours.
+func ParseQuietHours(s string) (*QuietHours, error) {
+	if s == "" {
+		return nil, nil
+	}
+	q := new(QuietHours)
+	if _, err := fmt.Sscanf(s, "%d-%d", &q.Start, &q.End); err != nil {
+		return nil, errors.New("quiet hours should look like 8-18: " + s)
+	}
+	if q.Start < 0 || q.Start > 23 || q.End < 0 || q.End > 23 {
+		return nil, errors.New("quiet hours should be between 0 and 23: " + s)
+	}
+	return q, nil
+}
+
+func (q *QuietHours) Contains(t time.Time) bool {
+	if q == nil || q.Start == q.End {
+		return false
+	}
+	h := t.Hour()
+	if q.Start < q.End {
+		return h >= q.Start && h < q.End
+	}
+	return h >= q.Start || h < q.End
+}
+
+// The first moment at or after t that is not within the quiet hours.
+func (q *QuietHours) After(t time.Time) time.Time {
+	if !q.Contains(t) {
+		return t
+	}
+	end := time.Date(t.Year(), t.Month(), t.Day(), q.End, 0, 0, 0, t.Location())
+	if !end.After(t) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
+
+// Periodically refresh the BWBIdList and sync the snapshots of the regelingen
+// that changed.
+type Schedule struct {
+	ConnectionURL string
+	Source        Fetcher
+	Strategy      SyncStrategy
+	Filter        SyncFilter
+	Interval      time.Duration // Time between the starts of two runs, 0 to run once
+	Quiet         *QuietHours
+	Workers       int
+}
+
+// Do a single run: reload the BWBIdList and sync everything that changed.
+// If the list cannot be loaded the regelingen we already know are synced.
+func (s Schedule) Run(ctx context.Context) (SyncSummary, error) {
+	if err := StoreBWBIdList(ctx, s.ConnectionURL, s.Source); err != nil {
+		log.Println("Could not load the BWBIdList, syncing the known regelingen:", err)
+	}
+	return SyncSnapshots(ctx, s.ConnectionURL, s.Filter, s.Strategy, s.Workers)
+}
+
+// Sleep for d, returning false if the context is cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Run the schedule until the context is cancelled, or after the first run if
+// there is no interval.
+func keepBWBSynced(ctx context.Context, s Schedule) {
+	for run := 1; ; run++ {
+		start := s.Quiet.After(time.Now())
+		if wait := start.Sub(time.Now()); wait > 0 {
+			log.Println("Quiet hours, postponing sync run", run, "until", start.Format("15:04"))
+			if !sleep(ctx, wait) {
+				return
+			}
+		}
+
+		start = time.Now()
+		log.Println("Starting sync run", run)
+		if summary, err := s.Run(ctx); err != nil {
+			log.Println("Sync run", run, "failed after", time.Since(start), err)
+		} else {
+			log.Println("Sync run", run, "finished in", time.Since(start), summary)
+		}
+
+		if s.Interval <= 0 || ctx.Err() != nil {
+			return
+		}
+		next := start.Add(s.Interval)
+		log.Println("Next sync run at", next.Format("02-01-2006 15:04"))
+		if !sleep(ctx, next.Sub(time.Now())) {
+			return
+		}
+	}
+}
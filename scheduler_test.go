@@ -0,0 +1,51 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"context"
+	"testing"
+)
+
+func TestScheduleRunWithoutList(t *testing.T) {
+	db, connectionURL := testDatabase(t)
+	defer db.Close()
+	fetcher, stop := fixtureFetcher()
+	defer stop()
+
+	if err := StoreBWBIdList(context.Background(), connectionURL, fetcher); err != nil {
+		t.Fatal(err)
+	}
+	// The list cannot be downloaded, but the known regeling is still synced
+	s := Schedule{
+		ConnectionURL: connectionURL,
+		Source:        DirFetcher{t.TempDir()},
+		Strategy:      ProbeStrategy{fetcher},
+		Filter:        SyncFilter{BWBIds: []string{"BWBR0005537"}},
+		Workers:       1,
+	}
+	if _, err := s.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var versions int
+	if err := db.QueryRow("SELECT COUNT(*) FROM bwb_snapshots WHERE bwbid=$1", "BWBR0005537").Scan(&versions); err != nil {
+		t.Fatal(err)
+	}
+	if versions != len(fixtureVersions) {
+		t.Errorf("stored %d versions, want %d", versions, len(fixtureVersions))
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"encoding/xml"
+	"io"
+	"time"
+)
+
+type StreamEventType int
+
+const (
+	NodeStart   StreamEventType = iota // A structural element was opened
+	NodeEnd                            // A structural element was closed
+	ArtikelNode                        // A complete article was read
+	BijlageNode                        // A complete bijlage was read
+	BlokNode                           // Text directly in a structural element was read
+)
+
+// The elements holding the body of the different kinds of regelingen,
+// mapped to the element holding their structured text.
+var bodyElementen = map[string]string{
+	"wet-besluit": "wettekst",
+	"regeling":    "regeling-tekst",
+	"circulaire":  "circulaire-tekst",
+	"verdrag":     "verdragtekst",
+}
+
+var tekstElementen = map[string]bool{
+	"wettekst":         true,
+	"regeling-tekst":   true,
+	"circulaire-tekst": true,
+	"verdragtekst":     true,
+}
+
+// An event emitted by BWBStream. Node never has Children; Parents holds the
+// open structural elements from the wettekst down and is only valid until
+// the next call to Next. Bijlage is only set for BijlageNode events and Blok
+// only for BlokNode events.
+type StreamEvent struct {
+	Type    StreamEventType
+	Node    StructuurNode
+	Parents []*StructuurNode
+	Bijlage *BijlageType
+	Blok    *BlokType
+}
+
+// BWBStream reads a BWB document token by token and emits its structural
+// nodes one at a time, so a document never has to be held in memory as a
+// whole. Only articles are decoded completely.
+type BWBStream struct {
+	// The attributes, intitule and aanhef of the document. These precede the
+	// wettekst and are filled in by the time the first node is emitted.
+	Header WetgevingType
+
+	d       *xml.Decoder
+	stack   []*StructuurNode
+	emitted int // The number of nodes on the stack whose start was emitted
+	queue   []StreamEvent
+}
+
+func NewBWBStream(r io.Reader) *BWBStream {
+	return &BWBStream{d: xml.NewDecoder(r)}
+}
+
+func (s *BWBStream) emit(t StreamEventType, node StructuurNode, parents []*StructuurNode) {
+	node.Children = nil
+	s.queue = append(s.queue, StreamEvent{t, node, parents, nil, nil})
+}
+
+// Whether element is an unknown wrapper rather than a structural element.
+func onbekendElement(element string) bool {
+	return !structuurElementen[element] && !tekstElementen[element]
+}
+
+// Emit the starts of the nodes on the stack that have not been emitted. An
+// unknown wrapper only becomes a node once an article or a structural
+// element is found in it, so unless all is set the nodes from the first
+// unknown wrapper on stay pending.
+func (s *BWBStream) flush(all bool) {
+	for s.emitted < len(s.stack) {
+		node := s.stack[s.emitted]
+		if !all && onbekendElement(node.Element) {
+			return
+		}
+		s.emit(NodeStart, *node, s.stack[:s.emitted])
+		s.emitted++
+	}
+}
+
+// The emitted nodes, i.e. the parents of content found now.
+func (s *BWBStream) parents() []*StructuurNode {
+	return s.stack[:s.emitted]
+}
+
+// The BWB id of the document, from the wetgeving element or else from the
+// toestand around it.
+func (s *BWBStream) BWBID() string {
+	if s.Header.BWBID != "" {
+		return s.Header.BWBID
+	}
+	return s.Header.Toestand.BWBID
+}
+
+// Return the next event, or io.EOF at the end of the document.
+func (s *BWBStream) Next() (StreamEvent, error) {
+	for len(s.queue) == 0 {
+		if err := s.step(); err != nil {
+			return StreamEvent{}, err
+		}
+	}
+	ev := s.queue[0]
+	s.queue = s.queue[1:]
+	return ev, nil
+}
+
+func (s *BWBStream) step() error {
+	t, err := s.d.Token()
+	if err != nil {
+		return err
+	}
+	inWettekst := len(s.stack) > 0
+	switch t := t.(type) {
+	case xml.StartElement:
+		name := t.Name.Local
+		switch {
+		case inWettekst && name == "kop":
+			top := s.stack[len(s.stack)-1]
+			if s.emitted == len(s.stack) {
+				return s.d.Skip()
+			}
+			if err := s.d.DecodeElement(&top.Kop, &t); err != nil {
+				return err
+			}
+			s.flush(false)
+		case inWettekst && name == "meta-data":
+			// Meta-data of a structural element, like its jcis and brondata
+			return s.d.Skip()
+		case inWettekst && name == "artikel":
+			s.flush(true)
+			artikel := new(ArtikelType)
+			if err := s.d.DecodeElement(artikel, &t); err != nil {
+				return err
+			}
+			s.emit(ArtikelNode, StructuurNode{
+				Element: "artikel",
+				Status:  artikel.Status,
+				Kop:     KopType{artikel.Label, artikel.Nr, artikel.Titel},
+				Artikel: artikel,
+			}, s.parents())
+		case name == "bijlage":
+			s.flush(false)
+			bijlage := new(BijlageType)
+			if err := s.d.DecodeElement(bijlage, &t); err != nil {
+				return err
+			}
+			s.queue = append(s.queue, StreamEvent{BijlageNode, StructuurNode{Element: "bijlage", Status: bijlage.Status, Kop: bijlage.Kop}, s.parents(), bijlage, nil})
+		case inWettekst && (name == "al" || name == "lijst" || name == "table"):
+			s.flush(false)
+			return s.emitBlok(t)
+		case inWettekst && name == "tekst":
+			s.flush(false)
+			return s.decodeTekst()
+		case tekstElementen[name] || inWettekst:
+			// Unknown wrappers in the wettekst are descended into, so the
+			// articles they contain are not lost. Validate reports the
+			// wrappers that turn out to contain any.
+			s.flush(false)
+			s.stack = append(s.stack, &StructuurNode{Element: name, Status: attrValue(t, "status")})
+		case name == "toestand":
+			s.Header.Toestand.BWBID = attrValue(t, "bwb-id")
+			s.Header.Toestand.Inwerkingtreding = attrValue(t, "inwerkingtreding")
+		case name == "geldigheid":
+			s.Header.Toestand.decodeGeldigheid(t)
+			return s.d.Skip()
+		case name == "meta-data":
+			return s.Header.Toestand.decodeMetaData(s.d)
+		case name == "wetgeving":
+			s.Header.BWBID = attrValue(t, "bwb-id")
+			s.Header.DTDVersie = attrValue(t, "dtdversie")
+			s.Header.ID = attrValue(t, "id")
+			s.Header.Soort = attrValue(t, "soort")
+			s.Header.Lang = attrValue(t, "lang")
+		case name == "intitule":
+			s.Header.Intitule.Id = attrValue(t, "id")
+			if s.Header.Intitule.Data, err = readText(s.d); err != nil {
+				return err
+			}
+		case name == "aanhef":
+			return s.d.DecodeElement(&s.Header.Aanhef, &t)
+		case bodyElementen[name] != "":
+			// Descend into the body
+			s.Header.Vorm = name
+		default:
+			return s.d.Skip()
+		}
+	case xml.EndElement:
+		if inWettekst {
+			s.flush(false)
+			if s.emitted == len(s.stack) {
+				s.emit(NodeEnd, *s.stack[len(s.stack)-1], s.stack[:len(s.stack)-1])
+				s.emitted--
+			}
+			// Nodes in an unknown wrapper without articles are left out
+			s.stack = s.stack[:len(s.stack)-1]
+		}
+	}
+	return nil
+}
+
+func (s *BWBStream) emitBlok(start xml.StartElement) error {
+	blok, err := decodeBlok(s.d, start)
+	if err != nil {
+		return err
+	}
+	s.queue = append(s.queue, StreamEvent{BlokNode, StructuurNode{Element: start.Name.Local}, s.parents(), nil, &blok})
+	return nil
+}
+
+// Emit the alineas, lists and tables in a tekst element, as used in
+// circulaires.
+func (s *BWBStream) decodeTekst() error {
+	for {
+		t, err := s.d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "al", "lijst", "table":
+				if err := s.emitBlok(t); err != nil {
+					return err
+				}
+			default:
+				if err := s.d.Skip(); err != nil {
+					return err
+				}
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// Read a complete document from r and build its structural tree.
+func ParseBWBReader(r io.Reader) (WetgevingType, error) {
+	s := NewBWBStream(r)
+	stack := []*StructuurNode{}
+	var wettekst StructuurNode
+	bijlagen := []BijlageType{}
+	var err error
+	for {
+		var ev StreamEvent
+		ev, err = s.Next()
+		if err != nil {
+			break
+		}
+		switch ev.Type {
+		case NodeStart:
+			node := ev.Node
+			stack = append(stack, &node)
+		case ArtikelNode:
+			top := stack[len(stack)-1]
+			top.Children = append(top.Children, ev.Node)
+		case BijlageNode:
+			bijlagen = append(bijlagen, *ev.Bijlage)
+		case BlokNode:
+			top := stack[len(stack)-1]
+			top.Blokken = append(top.Blokken, *ev.Blok)
+		case NodeEnd:
+			node := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				wettekst = *node
+			} else {
+				top := stack[len(stack)-1]
+				top.Children = append(top.Children, *node)
+			}
+		}
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	wetgeving := s.Header
+	wetgeving.BWBID = s.BWBID()
+	wetgeving.Wettekst = wettekst
+	wetgeving.Artikelen = wetgeving.Wettekst.Artikelen()
+	wetgeving.Bijlagen = bijlagen
+	geldigheid, _ := time.Parse(JCIDateFmt, wetgeving.Toestand.GeldigVan)
+	wetgeving.AssignJCIs(geldigheid)
+	return wetgeving, err
+}
@@ -0,0 +1,80 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBWBStream(t *testing.T) {
+	s := NewBWBStream(strings.NewReader(testDocument))
+	events := []string{}
+	for {
+		ev, err := s.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		parents := []string{}
+		for _, p := range ev.Parents {
+			parents = append(parents, p.Element)
+		}
+		prefix := strings.Join(parents, "/") + ": "
+		switch ev.Type {
+		case NodeStart:
+			events = append(events, prefix+"start "+ev.Node.Element+" "+ev.Node.Kop.Nr)
+		case NodeEnd:
+			events = append(events, prefix+"end "+ev.Node.Element)
+		case ArtikelNode:
+			events = append(events, prefix+"artikel "+ev.Node.Artikel.Nr)
+		case BijlageNode:
+			events = append(events, prefix+"bijlage "+ev.Bijlage.Kop.Nr)
+		case BlokNode:
+			events = append(events, prefix+"blok")
+		}
+	}
+	want := []string{
+		": start wettekst ",
+		"wettekst: start hoofdstuk 1",
+		"wettekst/hoofdstuk: artikel 1:1",
+		"wettekst/hoofdstuk: artikel 1:2",
+		"wettekst: end hoofdstuk",
+		"wettekst: artikel 99",
+		": end wettekst",
+		": bijlage 1",
+	}
+	if strings.Join(events, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got events\n%s\nwant\n%s", strings.Join(events, "\n"), strings.Join(want, "\n"))
+	}
+	if s.BWBID() != "BWBR0005537" || s.Header.Intitule.Data != "Algemene wet bestuursrecht" {
+		t.Errorf("header is %+v", s.Header)
+	}
+}
+
+// Validating while streaming finds the same problems as validating the
+// parsed tree.
+func TestValidateBWB(t *testing.T) {
+	for _, test := range validationTests {
+		checkProblems(t, test.name, ValidateBWB(strings.NewReader(test.document)), test.problems)
+	}
+	if problems := ValidateBWB(strings.NewReader("<wetgeving><intitule>")); len(problems) != 1 || problems[0].Kind != ParseError {
+		t.Errorf("got %v for a truncated document, want a parse error", problems)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"database/sql"
+	_ "github.com/lib/pq"
+	"strconv"
+	"time"
+)
+
+// States of a row in sync_jobs
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// How long a worker may hold a job before another worker may take it over,
+// e.g. because the process holding it was killed.
+const syncLeaseDuration = 2 * time.Hour
+
+// How often a job is attempted before it is marked as failed.
+const syncMaxAttempts = 3
+
+// Count the jobs that are pending or running.
+func UnfinishedSyncJobs(db *sql.DB) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sync_jobs WHERE state IN ($1, $2)", JobPending, JobRunning).Scan(&count)
+	return count, err
+}
+
+// Queue a job for every document matching the filter. Jobs that are already
+// pending or running are left alone.
+func EnqueueSyncJobs(db *sql.DB, filter SyncFilter) (int64, error) {
+	where, args := filter.where()
+	args = append(args, JobPending)
+	result, err := db.Exec(`INSERT INTO sync_jobs (bwbid, state, attempts, last_error, created, updated)
+		SELECT bwbid, $`+strconv.Itoa(len(args))+`, 0, NULL, now(), now() FROM bwb_documents WHERE `+where+`
+		ON CONFLICT (bwbid) DO UPDATE
+		SET state = EXCLUDED.state, attempts = 0, last_error = NULL, leased_until = NULL, updated = now()
+		WHERE sync_jobs.state IN ('`+JobDone+`', '`+JobFailed+`')`, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Take the oldest pending job, or a running job whose lease expired. Workers
+// in several processes can lease concurrently; SKIP LOCKED makes sure each
+// job is handed out once. Returns sql.ErrNoRows when there is no work.
+func LeaseSyncJob(db *sql.DB) (string, error) {
+	var bwbid string
+	err := db.QueryRow(`UPDATE sync_jobs
+		SET state = $1, attempts = attempts + 1, leased_until = now() + $2 * interval '1 second', updated = now()
+		WHERE bwbid = (
+			SELECT bwbid FROM sync_jobs
+			WHERE state = $3 OR (state = $1 AND leased_until < now())
+			ORDER BY updated
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING bwbid`, JobRunning, int(syncLeaseDuration.Seconds()), JobPending).Scan(&bwbid)
+	return bwbid, err
+}
+
+func CompleteSyncJob(db *sql.DB, bwbid string) error {
+	_, err := db.Exec("UPDATE sync_jobs SET state=$2, last_error=NULL, leased_until=NULL, updated=now() WHERE bwbid=$1", bwbid, JobDone)
+	return err
+}
+
+// Record the error of a job. The job is retried until it has been attempted
+// syncMaxAttempts times.
+func FailSyncJob(db *sql.DB, bwbid string, syncErr error) error {
+	_, err := db.Exec(`UPDATE sync_jobs
+		SET state = CASE WHEN attempts >= $3 THEN $4 ELSE $5 END,
+		last_error = $2, leased_until = NULL, updated = now()
+		WHERE bwbid = $1`, bwbid, syncErr.Error(), syncMaxAttempts, JobFailed, JobPending)
+	return err
+}
+
+type FailedSyncJob struct {
+	BWBID     string
+	Attempts  int
+	LastError string
+	Updated   time.Time
+}
+
+func FailedSyncJobs(db *sql.DB) ([]FailedSyncJob, error) {
+	rows, err := db.Query("SELECT bwbid, attempts, last_error, updated FROM sync_jobs WHERE state=$1 ORDER BY updated DESC", JobFailed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	jobs := []FailedSyncJob{}
+	for rows.Next() {
+		job := FailedSyncJob{}
+		if err := rows.Scan(&job.BWBID, &job.Attempts, &job.LastError, &job.Updated); err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
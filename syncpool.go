@@ -0,0 +1,117 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// The outcome of syncing a single document.
+type SyncResult struct {
+	BWBID    string
+	Err      error
+	Duration time.Duration
+}
+
+type SyncSummary struct {
+	Succeeded int
+	Failed    int
+	Skipped   int // Jobs left in the queue because the run was cancelled
+	Failures  []SyncResult
+}
+
+func (s SyncSummary) String() string {
+	return fmt.Sprintf("%d succeeded, %d failed, %d skipped", s.Succeeded, s.Failed, s.Skipped)
+}
+
+// A number of workers that take jobs from sync_jobs until the queue is empty
+// or the context is cancelled. A job that is running when the context is
+// cancelled is finished first.
+type SyncPool struct {
+	DB       *sql.DB
+	Strategy SyncStrategy
+	Size     int
+}
+
+func (p SyncPool) Run(ctx context.Context) SyncSummary {
+	size := p.Size
+	if size < 1 {
+		size = 1
+	}
+	results := make(chan SyncResult)
+	var wg sync.WaitGroup
+	for i := 0; i < size; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx, results)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	summary := SyncSummary{}
+	for result := range results {
+		if result.Err != nil {
+			log.Println("Sync of", result.BWBID, "failed:", result.Err)
+			summary.Failed++
+			summary.Failures = append(summary.Failures, result)
+		} else {
+			summary.Succeeded++
+		}
+	}
+	if ctx.Err() != nil {
+		unfinished, err := UnfinishedSyncJobs(p.DB)
+		if err != nil {
+			log.Println(err)
+		}
+		summary.Skipped = unfinished
+	}
+	log.Println("Sync finished:", summary)
+	return summary
+}
+
+func (p SyncPool) work(ctx context.Context, results chan<- SyncResult) {
+	for ctx.Err() == nil {
+		bwbid, err := LeaseSyncJob(p.DB)
+		if err == sql.ErrNoRows {
+			return
+		} else if err != nil {
+			log.Println(err)
+			return
+		}
+		start := time.Now()
+		result := SyncResult{BWBID: bwbid}
+		if result.Err = LoadBWBSnapshots(ctx, p.DB, p.Strategy, bwbid); result.Err != nil {
+			err = FailSyncJob(p.DB, bwbid, result.Err)
+		} else {
+			err = CompleteSyncJob(p.DB, bwbid)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+		result.Duration = time.Since(start)
+		results <- result
+	}
+}
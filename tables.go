@@ -0,0 +1,415 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"strconv"
+	"strings"
+)
+
+// A bijlage (annex) of a regeling.
+type BijlageType struct {
+	Status  string
+	Kop     KopType
+	Blokken []BlokType
+	JCI     JCI
+}
+
+// A CALS table as used in BWB documents.
+type TableType struct {
+	Title  string
+	Groups []TGroupType
+}
+
+type TGroupType struct {
+	Cols     int
+	ColSpecs []ColSpecType
+	Head     []RowType
+	Body     []RowType
+}
+
+type ColSpecType struct {
+	Name  string
+	Num   int
+	Width string
+}
+
+type RowType struct {
+	Entries []EntryType
+}
+
+// A table cell. Colspan and Rowspan are derived from the namest, nameend
+// and morerows attributes.
+type EntryType struct {
+	Tekst   string
+	Align   string
+	Colspan int
+	Rowspan int
+}
+
+func (b *BijlageType) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	b.Status = attrValue(start, "status")
+	depth := 1
+	for depth > 0 {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "kop":
+				kop := KopType{}
+				if err := d.DecodeElement(&kop, &t); err != nil {
+					return err
+				}
+				if b.Kop == (KopType{}) {
+					b.Kop = kop
+				} else {
+					// Headings of nested divisions become text
+					b.Blokken = append(b.Blokken, BlokType{Al: strings.TrimSpace(kop.Label + " " + kop.Nr + " " + kop.Titel)})
+				}
+			case "al", "lijst", "table":
+				blok, err := decodeBlok(d, t)
+				if err != nil {
+					return err
+				}
+				b.Blokken = append(b.Blokken, blok)
+			case "meta-data":
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			default:
+				// Descend into divisions and other wrappers
+				depth++
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+	return nil
+}
+
+func (tbl *TableType) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "title":
+				if tbl.Title, err = readText(d); err != nil {
+					return err
+				}
+			case "tgroup":
+				group := TGroupType{}
+				if err := d.DecodeElement(&group, &t); err != nil {
+					return err
+				}
+				tbl.Groups = append(tbl.Groups, group)
+			default:
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+func (g *TGroupType) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	g.Cols, _ = strconv.Atoi(attrValue(start, "cols"))
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "colspec":
+				spec := ColSpecType{Name: attrValue(t, "colname"), Width: attrValue(t, "colwidth")}
+				if spec.Num, err = strconv.Atoi(attrValue(t, "colnum")); err != nil {
+					spec.Num = len(g.ColSpecs) + 1
+				}
+				g.ColSpecs = append(g.ColSpecs, spec)
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			case "thead":
+				if g.Head, err = g.decodeRows(d); err != nil {
+					return err
+				}
+			case "tbody":
+				if g.Body, err = g.decodeRows(d); err != nil {
+					return err
+				}
+			default:
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// The column number of a colspec name, or 0 if it is unknown.
+func (g *TGroupType) colnum(name string) int {
+	for _, spec := range g.ColSpecs {
+		if spec.Name == name {
+			return spec.Num
+		}
+	}
+	return 0
+}
+
+// Decode the rows of a thead or tbody element.
+func (g *TGroupType) decodeRows(d *xml.Decoder) ([]RowType, error) {
+	rows := []RowType{}
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return rows, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "row" {
+				if err := d.Skip(); err != nil {
+					return rows, err
+				}
+				continue
+			}
+			row, err := g.decodeRow(d)
+			if err != nil {
+				return rows, err
+			}
+			rows = append(rows, row)
+		case xml.EndElement:
+			return rows, nil
+		}
+	}
+}
+
+func (g *TGroupType) decodeRow(d *xml.Decoder) (RowType, error) {
+	row := RowType{}
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return row, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "entry" {
+				if err := d.Skip(); err != nil {
+					return row, err
+				}
+				continue
+			}
+			entry := EntryType{Align: attrValue(t, "align"), Colspan: 1, Rowspan: 1}
+			if start, end := g.colnum(attrValue(t, "namest")), g.colnum(attrValue(t, "nameend")); start > 0 && end > start {
+				entry.Colspan = end - start + 1
+			}
+			if more, err := strconv.Atoi(attrValue(t, "morerows")); err == nil && more > 0 {
+				entry.Rowspan = more + 1
+			}
+			if entry.Tekst, err = readText(d); err != nil {
+				return row, err
+			}
+			row.Entries = append(row.Entries, entry)
+		case xml.EndElement:
+			return row, nil
+		}
+	}
+}
+
+func (r RowType) Tekst() string {
+	cells := []string{}
+	for _, e := range r.Entries {
+		cells = append(cells, e.Tekst)
+	}
+	return strings.Join(cells, " | ")
+}
+
+// All rows of the table, headers first.
+func (tbl *TableType) Rows() []RowType {
+	rows := []RowType{}
+	for _, g := range tbl.Groups {
+		rows = append(rows, g.Head...)
+		rows = append(rows, g.Body...)
+	}
+	return rows
+}
+
+func (tbl *TableType) Tekst() string {
+	parts := []string{}
+	if tbl.Title != "" {
+		parts = append(parts, tbl.Title)
+	}
+	for _, r := range tbl.Rows() {
+		parts = append(parts, r.Tekst())
+	}
+	return strings.Join(parts, "\n")
+}
+
+func (b *BijlageType) Tekst() string {
+	return blokkenTekst(b.Blokken)
+}
+
+// The tables in the bijlage, including those nested in lists.
+func (b *BijlageType) Tables() []*TableType {
+	return tablesIn(b.Blokken)
+}
+
+func tablesIn(blokken []BlokType) []*TableType {
+	tables := []*TableType{}
+	for _, blok := range blokken {
+		switch {
+		case blok.Table != nil:
+			tables = append(tables, blok.Table)
+		case blok.Lijst != nil:
+			for _, li := range blok.Lijst.Items {
+				tables = append(tables, tablesIn(li.Blokken)...)
+			}
+		}
+	}
+	return tables
+}
+
+// A changed cell of a table. OldRow and NewRow are the rows of the cell in
+// the old and the new table, zero based and counting the header rows first.
+// OldRow is -1 for an added row and NewRow is -1 for a removed row.
+type CellChange struct {
+	Kind   ChangeKind
+	Table  int
+	OldRow int
+	NewRow int
+	Col    int
+	Old    string
+	New    string
+}
+
+// The position of the cell for display, counting from one. Rows are those
+// of the new table, except for removed rows.
+func (c CellChange) Position() string {
+	switch {
+	case c.NewRow < 0:
+		return fmt.Sprintf("tabel %d, oude rij %d, kolom %d", c.Table+1, c.OldRow+1, c.Col+1)
+	case c.OldRow < 0 || c.OldRow == c.NewRow:
+		return fmt.Sprintf("tabel %d, rij %d, kolom %d", c.Table+1, c.NewRow+1, c.Col+1)
+	}
+	return fmt.Sprintf("tabel %d, rij %d (was %d), kolom %d", c.Table+1, c.NewRow+1, c.OldRow+1, c.Col+1)
+}
+
+func (c CellChange) TekstDiff() template.HTML {
+	return WordDiffHTML(c.Old, c.New)
+}
+
+// Compare two tables cell by cell. Inserted and deleted rows are found by
+// aligning the rows on their text, so a new row does not mark every row
+// below it as modified.
+func DiffTables(old, new *TableType) []CellChange {
+	var oldRows, newRows []RowType
+	if old != nil {
+		oldRows = old.Rows()
+	}
+	if new != nil {
+		newRows = new.Rows()
+	}
+	a := make([]string, len(oldRows))
+	for i, r := range oldRows {
+		a[i] = r.Tekst()
+	}
+	b := make([]string, len(newRows))
+	for j, r := range newRows {
+		b[j] = r.Tekst()
+	}
+
+	changes := []CellChange{}
+	rowCells := func(kind ChangeKind, row RowType, index int) {
+		for col, e := range row.Entries {
+			c := CellChange{Kind: kind, OldRow: -1, NewRow: -1, Col: col}
+			if kind == ArtikelRemoved {
+				c.OldRow, c.Old = index, e.Tekst
+			} else {
+				c.NewRow, c.New = index, e.Tekst
+			}
+			changes = append(changes, c)
+		}
+	}
+	// Rows removed and added between two unchanged rows are paired up as
+	// modified rows, in order, whichever of the two the diff lists first.
+	var deleted, inserted []int
+	flush := func() {
+		for len(deleted) > 0 && len(inserted) > 0 {
+			r, n := deleted[0], inserted[0]
+			deleted, inserted = deleted[1:], inserted[1:]
+			oldRow, newRow := oldRows[r], newRows[n]
+			for col := 0; col < len(oldRow.Entries) || col < len(newRow.Entries); col++ {
+				c := CellChange{Kind: ArtikelModified, OldRow: r, NewRow: n, Col: col}
+				if col < len(oldRow.Entries) {
+					c.Old = oldRow.Entries[col].Tekst
+				} else {
+					c.Kind = ArtikelAdded
+				}
+				if col < len(newRow.Entries) {
+					c.New = newRow.Entries[col].Tekst
+				} else {
+					c.Kind = ArtikelRemoved
+				}
+				if c.Old != c.New {
+					changes = append(changes, c)
+				}
+			}
+		}
+		for _, r := range deleted {
+			rowCells(ArtikelRemoved, oldRows[r], r)
+		}
+		for _, n := range inserted {
+			rowCells(ArtikelAdded, newRows[n], n)
+		}
+		deleted, inserted = nil, nil
+	}
+	i, j := 0, 0
+	for _, edit := range diffTokens(a, b) {
+		switch edit.Op {
+		case WordEqual:
+			flush()
+			i += len(edit.Words)
+			j += len(edit.Words)
+		case WordDelete:
+			for range edit.Words {
+				deleted = append(deleted, i)
+				i++
+			}
+		case WordInsert:
+			for range edit.Words {
+				inserted = append(inserted, j)
+				j++
+			}
+		}
+	}
+	flush()
+	return changes
+}
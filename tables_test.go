@@ -0,0 +1,111 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// A table with one header row and a body row per string, cells separated
+// by "|".
+func testTable(head string, body ...string) *TableType {
+	row := func(s string) RowType {
+		r := RowType{}
+		for _, cell := range strings.Split(s, "|") {
+			r.Entries = append(r.Entries, EntryType{Tekst: cell, Colspan: 1, Rowspan: 1})
+		}
+		return r
+	}
+	g := TGroupType{Head: []RowType{row(head)}}
+	for _, s := range body {
+		g.Body = append(g.Body, row(s))
+	}
+	return &TableType{Groups: []TGroupType{g}}
+}
+
+func formatChanges(changes []CellChange) []string {
+	s := []string{}
+	for _, c := range changes {
+		s = append(s, fmt.Sprintf("%s %d/%d,%d %q->%q", c.Kind, c.OldRow, c.NewRow, c.Col, c.Old, c.New))
+	}
+	return s
+}
+
+func TestDiffTables(t *testing.T) {
+	old := testTable("jaar|bedrag", "2013|100", "2014|110", "2015|120")
+	tests := []struct {
+		name string
+		new  *TableType
+		want []string
+	}{
+		{"unchanged", testTable("jaar|bedrag", "2013|100", "2014|110", "2015|120"), nil},
+		{
+			"modified cell",
+			testTable("jaar|bedrag", "2013|100", "2014|115", "2015|120"),
+			[]string{fmt.Sprintf("%s 2/2,1 \"110\"->\"115\"", ArtikelModified)},
+		},
+		{
+			// Only the new row is reported, not every row below it
+			"inserted row",
+			testTable("jaar|bedrag", "2012|90", "2013|100", "2014|110", "2015|120"),
+			[]string{
+				fmt.Sprintf("%s -1/1,0 \"\"->\"2012\"", ArtikelAdded),
+				fmt.Sprintf("%s -1/1,1 \"\"->\"90\"", ArtikelAdded),
+			},
+		},
+		{
+			"deleted row",
+			testTable("jaar|bedrag", "2013|100", "2015|120"),
+			[]string{
+				fmt.Sprintf("%s 2/-1,0 \"2014\"->\"\"", ArtikelRemoved),
+				fmt.Sprintf("%s 2/-1,1 \"110\"->\"\"", ArtikelRemoved),
+			},
+		},
+		{
+			"added column",
+			testTable("jaar|bedrag|index", "2013|100", "2014|110", "2015|120"),
+			[]string{fmt.Sprintf("%s 0/0,2 \"\"->\"index\"", ArtikelAdded)},
+		},
+	}
+	for _, test := range tests {
+		got := formatChanges(DiffTables(old, test.new))
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s:\ngot  %v\nwant %v", test.name, got, test.want)
+		}
+	}
+
+	// A row that moved keeps both numbers
+	moved := DiffTables(old, testTable("jaar|bedrag", "2012|90", "2013|100", "2014|115", "2015|120"))
+	if got := formatChanges(moved); len(got) != 3 || got[2] != fmt.Sprintf("%s 2/3,1 \"110\"->\"115\"", ArtikelModified) {
+		t.Errorf("moved row: got %v", got)
+	}
+	if p := moved[2].Position(); p != "tabel 1, rij 4 (was 3), kolom 2" {
+		t.Errorf("position of a moved row is %q", p)
+	}
+
+	// A table that appears or disappears is added or removed as a whole
+	if got := DiffTables(nil, old); len(got) != 8 || got[0].Kind != ArtikelAdded {
+		t.Errorf("new table: got %v", formatChanges(got))
+	}
+	if got := DiffTables(old, nil); len(got) != 8 || got[0].Kind != ArtikelRemoved {
+		t.Errorf("removed table: got %v", formatChanges(got))
+	} else if p := got[2].Position(); p != "tabel 1, oude rij 2, kolom 1" {
+		t.Errorf("position of a removed row is %q", p)
+	}
+}
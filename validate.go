@@ -0,0 +1,227 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"fmt"
+	"io"
+)
+
+type ProblemKind int
+
+const (
+	ParseError ProblemKind = iota
+	MissingAttribute
+	MissingElement
+	InvalidNesting
+	DuplicateArtikel
+)
+
+func (k ProblemKind) String() string {
+	switch k {
+	case ParseError:
+		return "parse-error"
+	case MissingAttribute:
+		return "missing-attribute"
+	case MissingElement:
+		return "missing-element"
+	case InvalidNesting:
+		return "invalid-nesting"
+	case DuplicateArtikel:
+		return "duplicate-artikel"
+	}
+	return "unknown"
+}
+
+// A violation of the structural rules of the BWB toestand schema. Path is
+// the location of the offending element, e.g.
+// "/wetgeving/wet-besluit/wettekst/hoofdstuk[2]/artikel[4]".
+type ValidationProblem struct {
+	Kind    ProblemKind
+	Path    string
+	Message string
+}
+
+func (p ValidationProblem) Error() string {
+	return fmt.Sprintf("%s: %s: %s", p.Path, p.Kind, p.Message)
+}
+
+// The structural elements each structural element may contain.
+var toegestaneKinderen = map[string][]string{
+	"wettekst":           {"boek", "deel", "titeldeel", "hoofdstuk", "afdeling", "paragraaf", "artikel", "divisie"},
+	"regeling-tekst":     {"hoofdstuk", "titeldeel", "afdeling", "paragraaf", "artikel", "divisie"},
+	"verdragtekst":       {"deel", "titeldeel", "hoofdstuk", "afdeling", "artikel", "divisie"},
+	"circulaire-tekst":   {"circulaire.divisie", "divisie"},
+	"circulaire.divisie": {"circulaire.divisie"},
+	"boek":               {"deel", "titeldeel", "hoofdstuk", "afdeling", "artikel", "divisie"},
+	"deel":               {"titeldeel", "hoofdstuk", "afdeling", "paragraaf", "artikel", "divisie"},
+	"titeldeel":          {"hoofdstuk", "afdeling", "paragraaf", "artikel", "divisie"},
+	"hoofdstuk":          {"titeldeel", "afdeling", "paragraaf", "artikel", "divisie"},
+	"afdeling":           {"paragraaf", "artikel", "divisie"},
+	"paragraaf":          {"sub-paragraaf", "artikel", "divisie"},
+	"sub-paragraaf":      {"artikel", "divisie"},
+	"divisie":            {"divisie", "artikel"},
+}
+
+func toegestaan(parent, child string) bool {
+	for _, e := range toegestaneKinderen[parent] {
+		if e == child {
+			return true
+		}
+	}
+	return false
+}
+
+// Check a parsed document against the structural rules of the BWB toestand
+// schema: required attributes, allowed nesting and unique article numbers.
+func (w *WetgevingType) Validate() []ValidationProblem {
+	v := newValidator()
+	if w.Wettekst.Element != "" {
+		v.replay(&w.Wettekst, w.Vorm)
+	}
+	return v.finish(w)
+}
+
+// Checks a document one stream event at a time, so a document can be
+// validated while it is read. Pass every event to event and the header of
+// the document to finish at the end.
+type validator struct {
+	root     string          // Path of the wettekst, empty until it is seen
+	frames   []validatorNode // The open structural elements
+	seen     map[string]bool // Article keys encountered so far
+	problems []ValidationProblem
+}
+
+type validatorNode struct {
+	element string
+	path    string
+	counts  map[string]int // Children per element name, for their paths
+}
+
+func newValidator() *validator {
+	return &validator{seen: make(map[string]bool)}
+}
+
+func (v *validator) add(kind ProblemKind, path, message string) {
+	v.problems = append(v.problems, ValidationProblem{kind, path, message})
+}
+
+// Check the nesting of a child of the innermost open element and return
+// its path.
+func (v *validator) child(n *StructuurNode) string {
+	parent := &v.frames[len(v.frames)-1]
+	parent.counts[n.Element]++
+	path := fmt.Sprintf("%s/%s[%d]", parent.path, n.Element, parent.counts[n.Element])
+	if n.Artikel == nil && !structuurElementen[n.Element] {
+		v.add(InvalidNesting, path, "unknown element "+n.Element+" in "+parent.element)
+	} else if _, known := toegestaneKinderen[parent.element]; known && !toegestaan(parent.element, n.Element) {
+		v.add(InvalidNesting, path, n.Element+" is not allowed in "+parent.element)
+	}
+	return path
+}
+
+// Check the next event of a document whose body is the element vorm.
+func (v *validator) event(ev StreamEvent, vorm string) {
+	switch ev.Type {
+	case NodeStart:
+		var path string
+		if len(v.frames) == 0 {
+			v.root = "/wetgeving/" + vorm + "/" + ev.Node.Element
+			path = v.root
+		} else {
+			path = v.child(&ev.Node)
+		}
+		v.frames = append(v.frames, validatorNode{ev.Node.Element, path, make(map[string]int)})
+	case NodeEnd:
+		if len(v.frames) > 0 {
+			v.frames = v.frames[:len(v.frames)-1]
+		}
+	case ArtikelNode:
+		if len(v.frames) == 0 {
+			return
+		}
+		path := v.child(&ev.Node)
+		if ev.Node.Kop.Nr == "" && ev.Node.Status != "vervallen" {
+			v.add(MissingElement, path, "artikel has no kop>nr")
+		}
+		// Articles without a number are reported above, not as duplicates
+		key := artikelKey(*ev.Node.Artikel)
+		if ev.Node.Kop.Nr != "" && v.seen[key] {
+			v.add(DuplicateArtikel, path, key+" occurs more than once")
+		}
+		v.seen[key] = true
+	}
+}
+
+// Feed a parsed tree to event as the stream would have emitted it.
+func (v *validator) replay(n *StructuurNode, vorm string) {
+	if n.Artikel != nil {
+		v.event(StreamEvent{Type: ArtikelNode, Node: *n}, vorm)
+		return
+	}
+	v.event(StreamEvent{Type: NodeStart, Node: *n}, vorm)
+	for i := range n.Children {
+		v.replay(&n.Children[i], vorm)
+	}
+	v.event(StreamEvent{Type: NodeEnd, Node: *n}, vorm)
+}
+
+// Check the header of the document and return all problems found.
+func (v *validator) finish(w *WetgevingType) []ValidationProblem {
+	problems := []ValidationProblem{}
+	if w.BWBID == "" {
+		problems = append(problems, ValidationProblem{MissingAttribute, "/wetgeving", "bwb-id is missing"})
+	}
+	if w.Soort == "" {
+		problems = append(problems, ValidationProblem{MissingAttribute, "/wetgeving", "soort is missing"})
+	}
+	if w.Intitule.Data == "" {
+		problems = append(problems, ValidationProblem{MissingElement, "/wetgeving", "intitule is missing"})
+	}
+	if w.Vorm == "" {
+		problems = append(problems, ValidationProblem{MissingElement, "/wetgeving", "wet-besluit, regeling, circulaire or verdrag is missing"})
+		return problems
+	}
+	if v.root == "" {
+		problems = append(problems, ValidationProblem{MissingElement, "/wetgeving/" + w.Vorm, bodyElementen[w.Vorm] + " is missing"})
+		return problems
+	}
+	problems = append(problems, v.problems...)
+	if len(v.seen) == 0 && w.Vorm != "circulaire" {
+		problems = append(problems, ValidationProblem{MissingElement, v.root, "document contains no articles"})
+	}
+	return problems
+}
+
+// Read and validate a document from r. Parse errors are reported as a
+// problem.
+func ValidateBWB(r io.Reader) []ValidationProblem {
+	stream := NewBWBStream(r)
+	v := newValidator()
+	for {
+		ev, err := stream.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return []ValidationProblem{{ParseError, "/", err.Error()}}
+		}
+		v.event(ev, stream.Header.Vorm)
+	}
+	header := stream.Header
+	header.BWBID = stream.BWBID()
+	return v.finish(&header)
+}
@@ -0,0 +1,120 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+var validationTests = []struct {
+	name     string
+	document string
+	problems []string
+}{
+	{"valid", testDocument, nil},
+	{
+		"missing attributes",
+		`<wetgeving><intitule>T</intitule><wet-besluit><wettekst>
+		<artikel><kop><label>Artikel</label><nr>1</nr></kop><al>a</al></artikel>
+		</wettekst></wet-besluit></wetgeving>`,
+		[]string{
+			"/wetgeving: missing-attribute: bwb-id is missing",
+			"/wetgeving: missing-attribute: soort is missing",
+		},
+	},
+	{
+		"duplicate and unnumbered articles",
+		`<wetgeving bwb-id="BWBR1" soort="wet"><intitule>T</intitule><wet-besluit><wettekst>
+		<artikel><kop><label>Artikel</label><nr>1</nr></kop><al>a</al></artikel>
+		<artikel><kop><label>Artikel</label><nr>1</nr></kop><al>b</al></artikel>
+		<artikel><al>c</al></artikel>
+		<artikel status="vervallen"><al>d</al></artikel>
+		</wettekst></wet-besluit></wetgeving>`,
+		[]string{
+			"/wetgeving/wet-besluit/wettekst/artikel[2]: duplicate-artikel: Artikel 1 occurs more than once",
+			"/wetgeving/wet-besluit/wettekst/artikel[3]: missing-element: artikel has no kop>nr",
+		},
+	},
+	{
+		"invalid nesting",
+		`<wetgeving bwb-id="BWBR1" soort="wet"><intitule>T</intitule><wet-besluit><wettekst>
+		<afdeling><hoofdstuk><artikel><kop><nr>1</nr></kop><al>a</al></artikel></hoofdstuk></afdeling>
+		<onbekend><artikel><kop><nr>2</nr></kop><al>b</al></artikel></onbekend>
+		</wettekst></wet-besluit></wetgeving>`,
+		[]string{
+			"/wetgeving/wet-besluit/wettekst/afdeling[1]/hoofdstuk[1]: invalid-nesting: hoofdstuk is not allowed in afdeling",
+			"/wetgeving/wet-besluit/wettekst/onbekend[1]: invalid-nesting: unknown element onbekend in wettekst",
+		},
+	},
+	{
+		"no articles",
+		`<wetgeving bwb-id="BWBR1" soort="wet"><intitule>T</intitule><wet-besluit><wettekst>
+		<hoofdstuk><kop><nr>1</nr></kop></hoofdstuk>
+		</wettekst></wet-besluit></wetgeving>`,
+		[]string{"/wetgeving/wet-besluit/wettekst: missing-element: document contains no articles"},
+	},
+	{
+		"missing wettekst",
+		`<wetgeving bwb-id="BWBR1" soort="wet"><intitule>T</intitule><wet-besluit></wet-besluit></wetgeving>`,
+		[]string{"/wetgeving/wet-besluit: missing-element: wettekst is missing"},
+	},
+}
+
+func checkProblems(t *testing.T, name string, problems []ValidationProblem, want []string) {
+	if len(problems) != len(want) {
+		t.Errorf("%s: got problems %v, want %v", name, problems, want)
+		return
+	}
+	for i, p := range problems {
+		if p.Error() != want[i] {
+			t.Errorf("%s: got problem %q, want %q", name, p.Error(), want[i])
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, test := range validationTests {
+		w, err := ParseBWB(test.document)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkProblems(t, test.name, w.Validate(), test.problems)
+	}
+}
+
+// Every fixture snapshot is a valid document.
+func TestValidateFixtures(t *testing.T) {
+	names, err := filepath.Glob("testdata/xml.php/*/*.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := ParseBWB(string(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if problems := w.Validate(); len(problems) > 0 {
+			t.Errorf("%s: %v", name, problems)
+		}
+	}
+}
@@ -37,6 +37,7 @@ type BWBstatistics struct {
 type StatusPage struct {
 	TotalSnapshots uint32
 	BWBStats       []BWBstatistics
+	FailedJobs     []FailedSyncJob
 }
 
 type SinglePage struct {
@@ -49,6 +50,17 @@ type SinglePage struct {
 	Versions      []string
 }
 
+type ComparePage struct {
+	Title     string
+	BWBID     string
+	OldDate   string
+	NewDate   string
+	OldParsed WetgevingType
+	NewParsed WetgevingType
+	Changes   ChangeSet
+	Versions  []string
+}
+
 func SimpleTimeFmt(t time.Time) string {
 	return t.Format(DateFmt)
 }
@@ -88,6 +100,11 @@ func statusHandler(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 	}
+	status.FailedJobs, err = FailedSyncJobs(db)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+	}
 	t, _ := template.ParseFiles("status.html")
 	t.Execute(w, status)
 }
@@ -102,18 +119,22 @@ func singleHandler(w http.ResponseWriter, r *http.Request) {
 	defer db.Close()
 	// Path should be of form "/single/[bwbid]/[date]/"
 	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
-	var bwbid, date string
+	var bwbid string
+	var date time.Time
 	if len(path) == 2 { // Display latest version when date is not specified
 		bwbid = path[1]
 	} else if len(path) == 3 {
 		bwbid = path[1]
-		date = path[2]
+		if date, err = time.Parse(DateFmt, path[2]); err != nil {
+			http.Error(w, "Invalid date", http.StatusBadRequest)
+			return
+		}
 	} else {
 		http.Error(w, "Document not found", http.StatusNotFound)
 		return
 	}
 	page := SinglePage{BWBID: bwbid}
-	if date == "" {
+	if date.IsZero() {
 		err = db.QueryRow("SELECT content, pubdate, (SELECT titel FROM bwb_documents WHERE bwbid=$1) FROM bwb_snapshots WHERE bwbid=$1 ORDER BY pubdate DESC LIMIT 1", bwbid).Scan(&page.Content, &page.PubDate, &page.Title)
 	} else {
 		err = db.QueryRow("SELECT content, pubdate, (SELECT titel FROM bwb_documents WHERE bwbid=$1) FROM bwb_snapshots WHERE bwbid=$1 AND pubdate <= $2 ORDER BY pubdate DESC LIMIT 1", bwbid, date).Scan(&page.Content, &page.PubDate, &page.Title)
@@ -134,7 +155,10 @@ func singleHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Parse error", http.StatusInternalServerError)
 		return
 	}
-	log.Printf("%#v\n", page.ParsedContent)
+	if page.ParsedContent.Toestand.GeldigVan == "" {
+		// Use the first day we have seen this version for the permalinks
+		page.ParsedContent.AssignJCIs(page.PubDate)
+	}
 	rows, err := db.Query(`SELECT pubdate FROM bwb_snapshots WHERE bwbid=$1 ORDER BY pubdate ASC;`, bwbid)
 	if err != nil {
 		log.Println(err)
@@ -158,8 +182,105 @@ func singleHandler(w http.ResponseWriter, r *http.Request) {
 	t.Execute(w, page)
 }
 
+// Load the newest snapshot of bwbid that was published on or before date.
+func loadSnapshot(db *sql.DB, bwbid string, date time.Time) (content string, pubdate time.Time, err error) {
+	err = db.QueryRow("SELECT content, pubdate FROM bwb_snapshots WHERE bwbid=$1 AND pubdate <= $2 ORDER BY pubdate DESC LIMIT 1", bwbid, date).Scan(&content, &pubdate)
+	return content, pubdate, err
+}
+
+func compareHandler(w http.ResponseWriter, r *http.Request) {
+	// Connect to the database
+	db, err := sql.Open("postgres", connectionURL)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+	// Path should be of form "/compare/[bwbid]/[date1]/[date2]/"
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(path) != 4 {
+		http.Error(w, "Document not found", http.StatusNotFound)
+		return
+	}
+	bwbid := path[1]
+	date1, err1 := time.Parse(DateFmt, path[2])
+	date2, err2 := time.Parse(DateFmt, path[3])
+	if err1 != nil || err2 != nil {
+		http.Error(w, "Invalid date", http.StatusBadRequest)
+		return
+	}
+	// Always show the oldest version on the left
+	if date2.Before(date1) {
+		date1, date2 = date2, date1
+	}
+	page := ComparePage{BWBID: bwbid}
+	err = db.QueryRow("SELECT titel FROM bwb_documents WHERE bwbid=$1", bwbid).Scan(&page.Title)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	var contents [2]string
+	var pubdates [2]time.Time
+	for i, date := range []time.Time{date1, date2} {
+		contents[i], pubdates[i], err = loadSnapshot(db, bwbid, date)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			log.Println(err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+	page.OldDate = SimpleTimeFmt(pubdates[0])
+	page.NewDate = SimpleTimeFmt(pubdates[1])
+	page.OldParsed, err = ParseBWB(contents[0])
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Parse error", http.StatusInternalServerError)
+		return
+	}
+	page.NewParsed, err = ParseBWB(contents[1])
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Parse error", http.StatusInternalServerError)
+		return
+	}
+	page.Changes = DiffWetgeving(&page.OldParsed, &page.NewParsed)
+	rows, err := db.Query(`SELECT pubdate FROM bwb_snapshots WHERE bwbid=$1 ORDER BY pubdate ASC;`, bwbid)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pubdate time.Time
+		if err := rows.Scan(&pubdate); err != nil {
+			log.Println(err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		page.Versions = append(page.Versions, SimpleTimeFmt(pubdate))
+	}
+	t, err := template.ParseFiles("compare.html")
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	t.Execute(w, page)
+}
+
 func startWebServer() {
 	http.HandleFunc("/status/", statusHandler)
 	http.HandleFunc("/single/", singleHandler)
+	http.HandleFunc("/compare/", compareHandler)
+	http.HandleFunc("/references/", referencesHandler)
+	http.HandleFunc("/jci/", jciHandler)
+	http.HandleFunc("/lists/", listsHandler)
+	http.HandleFunc("/lookup/", lookupHandler)
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
@@ -0,0 +1,194 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"bytes"
+	"html"
+	"html/template"
+	"strings"
+)
+
+type WordOp int
+
+const (
+	WordEqual WordOp = iota
+	WordInsert
+	WordDelete
+)
+
+type WordEdit struct {
+	Op    WordOp
+	Words []string
+}
+
+// Compute the word level edits that turn old into new. Words are separated
+// by whitespace; consecutive words with the same operation are grouped.
+func DiffWords(old, new string) []WordEdit {
+	return diffTokens(strings.Fields(old), strings.Fields(new))
+}
+
+// Compute the edits that turn the tokens a into b. Uses Myers' algorithm with
+// the linear space refinement, so memory stays proportional to the input and
+// time to the input times the number of edits.
+func diffTokens(a, b []string) []WordEdit {
+	edits := []WordEdit{}
+	add := func(op WordOp, words []string) {
+		for _, word := range words {
+			if n := len(edits); n > 0 && edits[n-1].Op == op {
+				edits[n-1].Words = append(edits[n-1].Words, word)
+				continue
+			}
+			edits = append(edits, WordEdit{op, []string{word}})
+		}
+	}
+	diffRange(a, b, add)
+	return edits
+}
+
+func diffRange(a, b []string, add func(WordOp, []string)) {
+	// Strip the common prefix and suffix
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	add(WordEqual, a[:prefix])
+	ma := a[prefix : len(a)-suffix]
+	mb := b[prefix : len(b)-suffix]
+
+	switch {
+	case len(ma) == 0:
+		add(WordInsert, mb)
+	case len(mb) == 0:
+		add(WordDelete, ma)
+	default:
+		x, y, ok := middleSnake(ma, mb)
+		if ok {
+			diffRange(ma[:x], mb[:y], add)
+			diffRange(ma[x:], mb[y:], add)
+		} else {
+			add(WordDelete, ma)
+			add(WordInsert, mb)
+		}
+	}
+	add(WordEqual, a[len(a)-suffix:])
+}
+
+// Find a point (x, y) on a shortest edit path from a to b by searching from
+// both ends until the paths overlap. a and b must be non-empty and differ in
+// their first and last tokens, which makes the point lie strictly inside.
+func middleSnake(a, b []string) (int, int, bool) {
+	n, m := len(a), len(b)
+	maxD := (n + m + 1) / 2
+	offset := maxD + 1
+	v1 := make([]int, 2*maxD+3)
+	v2 := make([]int, 2*maxD+3)
+	for i := range v1 {
+		v1[i] = -1
+		v2[i] = -1
+	}
+	v1[offset+1] = 0
+	v2[offset+1] = 0
+	delta := n - m
+	// With an odd delta the paths meet on a forward step, else on a reverse one
+	front := delta%2 != 0
+	k1start, k1end, k2start, k2end := 0, 0, 0, 0
+	for d := 0; d <= maxD; d++ {
+		// Forward from the start
+		for k1 := -d + k1start; k1 <= d-k1end; k1 += 2 {
+			i := offset + k1
+			var x1 int
+			if k1 == -d || (k1 != d && v1[i-1] < v1[i+1]) {
+				x1 = v1[i+1]
+			} else {
+				x1 = v1[i-1] + 1
+			}
+			y1 := x1 - k1
+			for x1 < n && y1 < m && a[x1] == b[y1] {
+				x1++
+				y1++
+			}
+			v1[i] = x1
+			if x1 > n {
+				k1end += 2
+			} else if y1 > m {
+				k1start += 2
+			} else if front {
+				j := offset + delta - k1
+				if j >= 0 && j < len(v2) && v2[j] != -1 && x1 >= n-v2[j] {
+					return x1, y1, true
+				}
+			}
+		}
+		// Backward from the end
+		for k2 := -d + k2start; k2 <= d-k2end; k2 += 2 {
+			i := offset + k2
+			var x2 int
+			if k2 == -d || (k2 != d && v2[i-1] < v2[i+1]) {
+				x2 = v2[i+1]
+			} else {
+				x2 = v2[i-1] + 1
+			}
+			y2 := x2 - k2
+			for x2 < n && y2 < m && a[n-x2-1] == b[m-y2-1] {
+				x2++
+				y2++
+			}
+			v2[i] = x2
+			if x2 > n {
+				k2end += 2
+			} else if y2 > m {
+				k2start += 2
+			} else if !front {
+				j := offset + delta - k2
+				if j >= 0 && j < len(v1) && v1[j] != -1 {
+					x1 := v1[j]
+					y1 := offset + x1 - j
+					if x1 >= n-x2 {
+						return x1, y1, true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// Render the word level difference between old and new as escaped HTML with
+// <del> and <ins> markup.
+func WordDiffHTML(old, new string) template.HTML {
+	var buf bytes.Buffer
+	for i, e := range DiffWords(old, new) {
+		if i > 0 {
+			buf.WriteString(" ")
+		}
+		text := html.EscapeString(strings.Join(e.Words, " "))
+		switch e.Op {
+		case WordEqual:
+			buf.WriteString(text)
+		case WordInsert:
+			buf.WriteString("<ins>" + text + "</ins>")
+		case WordDelete:
+			buf.WriteString("<del>" + text + "</del>")
+		}
+	}
+	return template.HTML(buf.String())
+}
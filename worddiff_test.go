@@ -0,0 +1,102 @@
+package main
+
+/*
+ * Copyright (c) 2014 Floor Terra <floort@gmail.com>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestWordDiffHTML(t *testing.T) {
+	tests := []struct {
+		old, new string
+		want     string
+	}{
+		{"een twee drie", "een twee drie", "een twee drie"},
+		{"een twee drie", "een vier drie", "een <del>twee</del> <ins>vier</ins> drie"},
+		{"een twee", "een twee drie vier", "een twee <ins>drie vier</ins>"},
+		{"een twee drie", "drie", "<del>een twee</del> drie"},
+		{"", "nieuw", "<ins>nieuw</ins>"},
+		{"oud", "", "<del>oud</del>"},
+		// Whitespace only separates words
+		{"een  twee\ndrie", "een twee drie", "een twee drie"},
+		// The text is escaped, the markup is not
+		{"a < b & c", "a > b & c", "a <del>&lt;</del> <ins>&gt;</ins> b &amp; c"},
+		{`<ins>"x"</ins>`, `<ins>"y"</ins>`, `<del>&lt;ins&gt;&#34;x&#34;&lt;/ins&gt;</del> <ins>&lt;ins&gt;&#34;y&#34;&lt;/ins&gt;</ins>`},
+	}
+	for _, test := range tests {
+		if got := string(WordDiffHTML(test.old, test.new)); got != test.want {
+			t.Errorf("%q -> %q:\ngot  %s\nwant %s", test.old, test.new, got, test.want)
+		}
+	}
+}
+
+// The length of the longest common subsequence of a and b.
+func lcsLength(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] > cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// The edits must turn a into b and keep as many words as possible.
+func TestDiffTokensMinimal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	words := func() []string {
+		s := make([]string, r.Intn(30))
+		for i := range s {
+			s[i] = string(rune('a' + r.Intn(4)))
+		}
+		return s
+	}
+	for n := 0; n < 5000; n++ {
+		a, b := words(), words()
+		var old, new []string
+		equal := 0
+		for _, e := range diffTokens(a, b) {
+			switch e.Op {
+			case WordEqual:
+				old = append(old, e.Words...)
+				new = append(new, e.Words...)
+				equal += len(e.Words)
+			case WordDelete:
+				old = append(old, e.Words...)
+			case WordInsert:
+				new = append(new, e.Words...)
+			}
+		}
+		if strings.Join(old, " ") != strings.Join(a, " ") || strings.Join(new, " ") != strings.Join(b, " ") {
+			t.Fatalf("%v -> %v: the edits give %v -> %v", a, b, old, new)
+		}
+		if want := lcsLength(a, b); equal != want {
+			t.Fatalf("%v -> %v: keeps %d words, want %d", a, b, equal, want)
+		}
+	}
+}